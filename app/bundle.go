@@ -0,0 +1,477 @@
+package app
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"github.com/paraterraform/para/app/index"
+	"github.com/paraterraform/para/utils"
+	yml "gopkg.in/ashald/yaml.v2"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	bundleManifest = "manifest.yaml" // always goes first so that it's known what to expect while unpacking the rest
+	bundleKindTool = "tool"
+	bundlesDir     = "bundles"
+)
+
+// bundleManifestFile lists everything in a bundle with sha256 digests to verify it on import
+type bundleManifestFile struct {
+	Created string         `yaml:"created"`
+	Index   string         `yaml:"index"` // location of the primary index the bundle was exported from
+	Entries []*bundleEntry `yaml:"entries"`
+}
+
+type bundleEntry struct {
+	Kind     string `yaml:"kind"` // kind of the plugin or 'tool'
+	Name     string `yaml:"name"`
+	Version  string `yaml:"version"`
+	Platform string `yaml:"platform"`
+	Path     string `yaml:"path"` // within the bundle
+	Size     int64  `yaml:"size"`
+	Digest   string `yaml:"digest"`
+	// the digest from the original index (of an archive if the file was extracted from one) - it goes to the generated
+	// index along with the sha256 so that lock files keep matching it
+	IndexDigest string `yaml:"index-digest,omitempty"`
+
+	source      string // where the file is taken from on export
+	indexDigest string // the digest from the index the file was downloaded by
+}
+
+// bundleIndexEntry is how a bundled file is listed in the generated index
+type bundleIndexEntry struct {
+	Url         string `yaml:"url"`
+	Size        int64  `yaml:"size"`
+	Digest      string `yaml:"digest"`
+	IndexDigest string `yaml:"index-digest,omitempty"`
+}
+
+// BundleExport downloads plugins from the index (all of them or just pinned ones, for all or just given platforms)
+// along with given versions of Terraform and Terragrunt and packs them into a single tarball with a manifest
+func BundleExport(
+	indexConfig IndexConfig, customCachePath string, refresh time.Duration, toolsConfig ToolsConfig,
+	output string, pinnedOnly bool, platforms []string, toolVersions map[string][]string,
+) {
+	cacheDir := openCacheDir(customCachePath)
+	loadingIndex, _, err := loadIndex(indexConfig, cacheDir, refresh, true)
+	if err != nil {
+		fmt.Printf("\n* Error: %s\n", err)
+		os.Exit(1)
+	}
+
+	plugins, err := selectPlugins(loadingIndex, indexConfig.Pins, pinnedOnly, platforms)
+	if err != nil {
+		fmt.Printf("* Error: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("- Plugins: %d for %s\n", len(plugins), strings.Join(listPlatforms(plugins), ", "))
+	entries := cacheBundlePlugins(loadingIndex.BuildRuntimeIndex(indexConfig.ParallelDownloads), plugins)
+
+	toolPlatforms := platforms
+	if len(toolPlatforms) == 0 {
+		toolPlatforms = []string{runtimePlatform()}
+	}
+	for _, name := range []string{terraformExec, terragruntExec} {
+		if len(toolVersions[name]) == 0 {
+			continue
+		}
+		t := findTool(name, toolsConfig, loadingIndex, cacheDir, refresh)
+		entries = append(entries, downloadBundleTool(t, toolVersions[name], toolPlatforms, toolsConfig.Insecure)...)
+	}
+
+	entries = dedupeBundleEntries(entries) // e.g. "--with-terraform 1.5.0 --with-terraform latest" may be the same
+	for _, entry := range entries {
+		err = entry.describeFile()
+		if err != nil {
+			fmt.Printf("* Error: cannot read '%s': %s\n", utils.PathSimplify(entry.source), err)
+			os.Exit(1)
+		}
+	}
+	manifest := &bundleManifestFile{
+		Created: time.Now().UTC().Format(time.RFC3339),
+		Index:   utils.UrlRedact(loadingIndex.Location),
+		Entries: entries,
+	}
+	err = writeBundle(output, manifest)
+	if err != nil {
+		fmt.Printf("* Error: cannot write bundle to '%s': %s\n", output, err)
+		os.Exit(1)
+	}
+	info, err := os.Stat(output)
+	if err != nil {
+		fmt.Printf("* Error: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf(
+		"- Bundle: %s (%d files, %s)\n", utils.PathSimplify(output), len(entries), utils.SizeFormat(info.Size()),
+	)
+}
+
+func bundlePluginPath(plugin *index.Plugin) string {
+	return path.Join("plugins", plugin.Kind, plugin.Name, plugin.Version, plugin.Platform)
+}
+
+// cacheBundlePlugins downloads plugins (or takes them from the cache dir) or exits on the first failure
+func cacheBundlePlugins(runtimeIndex *index.RuntimeIndex, plugins []*index.Plugin) []*bundleEntry {
+	var entries []*bundleEntry
+	for _, result := range prefetchPlugins(runtimeIndex, plugins, true) {
+		if result.err != nil {
+			fmt.Printf("* Error: cannot fetch '%s': %s\n", result.plugin.Url, result.err)
+			os.Exit(1)
+		}
+		entries = append(entries, &bundleEntry{
+			Kind:        result.plugin.Kind,
+			Name:        result.plugin.Name,
+			Version:     result.plugin.Version,
+			Platform:    result.plugin.Platform,
+			Path:        bundlePluginPath(result.plugin),
+			source:      result.path,
+			indexDigest: result.plugin.Digest,
+		})
+	}
+	return entries
+}
+
+// downloadBundleTool downloads all requested versions of the tool for every platform or exits on the first failure
+func downloadBundleTool(t tool, versions, platforms []string, insecure bool) []*bundleEntry {
+	title := toolTitle(t.name())
+	var entries []*bundleEntry
+	for _, raw := range versions {
+		requirement, err := parseVersionRequirement(raw, "bundle export")
+		if err != nil {
+			fmt.Printf("* Error: %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("- %s: '%s', downloading", title, requirement)
+		for _, platform := range platforms {
+			downloaded, err := downloadTool(t, requirement, platform, insecure)
+			if err != nil {
+				fmt.Printf("\n* Error: Para was unable to download %s for %s: %s\n", title, platform, err)
+				printVerificationHint(err)
+				os.Exit(1)
+			}
+			fmt.Printf(" %s for %s", downloaded.Version, platform)
+			if downloaded.Verification != "" {
+				fmt.Printf(" (%s)", downloaded.Verification)
+			}
+			version := "v" + strings.TrimPrefix(downloaded.Version, "v") // as it must be named in the index
+			entries = append(entries, &bundleEntry{
+				Kind:     bundleKindTool,
+				Name:     t.name(),
+				Version:  version,
+				Platform: platform,
+				Path:     path.Join(index.SectionTools, t.name(), version, platform, t.name()),
+				source:   filepath.Join(downloaded.Dir, t.name()),
+			})
+		}
+		fmt.Println()
+	}
+	return entries
+}
+
+// dedupeBundleEntries keeps the first entry for every path so that the same file is not written to the bundle twice
+func dedupeBundleEntries(entries []*bundleEntry) []*bundleEntry {
+	seen := make(map[string]bool)
+	var result []*bundleEntry
+	for _, entry := range entries {
+		if seen[entry.Path] {
+			continue
+		}
+		seen[entry.Path] = true
+		result = append(result, entry)
+	}
+	return result
+}
+
+// describeFile fills in the size and the digest of the file the entry is exported from
+func (e *bundleEntry) describeFile() error {
+	info, err := os.Stat(e.source)
+	if err != nil {
+		return err
+	}
+	e.Size = info.Size()
+	e.Digest, err = utils.DigestCompute(e.source, "sha256")
+	if err != nil {
+		return err
+	}
+	e.IndexDigest = e.indexDigest
+	return nil
+}
+
+// writeBundle writes a gzipped tarball with the manifest followed by all files it lists
+func writeBundle(output string, manifest *bundleManifestFile) error {
+	rawManifest, err := yml.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	file, err := utils.CreateFileAtomic(output)
+	if err != nil {
+		return err
+	}
+	compressed := gzip.NewWriter(file)
+	archive := tar.NewWriter(compressed)
+
+	err = archive.WriteHeader(&tar.Header{
+		Name: bundleManifest, Mode: 0644, Size: int64(len(rawManifest)), ModTime: time.Now(),
+	})
+	if err == nil {
+		_, err = archive.Write(rawManifest)
+	}
+	for _, entry := range manifest.Entries {
+		if err != nil {
+			break
+		}
+		err = writeBundleFile(archive, entry)
+	}
+	if err == nil {
+		err = archive.Close()
+	}
+	if err == nil {
+		err = compressed.Close()
+	}
+	return file.Commit(0644, err)
+}
+
+func writeBundleFile(archive *tar.Writer, entry *bundleEntry) error {
+	source, err := os.Open(entry.source)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = source.Close() }()
+
+	err = archive.WriteHeader(&tar.Header{Name: entry.Path, Mode: 0755, Size: entry.Size, ModTime: time.Now()})
+	if err != nil {
+		return err
+	}
+	// the file could have been changed since it was described but then it fails verification on import
+	_, err = io.CopyN(archive, source, entry.Size)
+	return err
+}
+
+// BundleImport verifies and unpacks a bundle into the cache dir and generates an index that lists its files by file://
+// URLs - so that Para can run without network access
+func BundleImport(customCachePath, bundlePath, indexPath string, force bool) {
+	cacheDir := openCacheDir(customCachePath)
+	if !force && utils.PathExists(indexPath) {
+		fmt.Printf("* Error: '%s' already exists - use --force to overwrite it\n", indexPath)
+		os.Exit(1)
+	}
+
+	fmt.Printf("- Bundle: %s", utils.PathSimplify(bundlePath))
+	unpackedDir, manifest, err := unpackBundle(bundlePath, filepath.Join(cacheDir, bundlesDir))
+	if err != nil {
+		fmt.Printf("\n* Error: cannot import the bundle: %s\n", err)
+		os.Exit(1)
+	}
+	var plugins, tools int
+	var size int64
+	for _, entry := range manifest.Entries {
+		if entry.Kind == bundleKindTool {
+			tools += 1
+		} else {
+			plugins += 1
+		}
+		size += entry.Size
+	}
+	fmt.Printf(
+		" (exported %s from %s): %d plugins, %d tools, %s verified\n",
+		manifest.Created, manifest.Index, plugins, tools, utils.SizeFormat(size),
+	)
+	fmt.Printf("- Unpacked To: %s\n", utils.PathSimplify(unpackedDir))
+
+	err = writeBundleIndex(indexPath, bundlePath, unpackedDir, manifest)
+	if err != nil {
+		fmt.Printf("* Error: cannot write index to '%s': %s\n", indexPath, err)
+		os.Exit(1)
+	}
+	fmt.Printf("- Index: %s\n", utils.PathSimplify(indexPath))
+}
+
+// unpackBundle extracts the bundle into a temp dir next to where it belongs, verifies everything listed in the manifest
+// and only then moves it into place - under a name derived from the manifest so that importing it again replaces it
+func unpackBundle(bundlePath, dir string) (string, *bundleManifestFile, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return "", nil, err
+	}
+	tempDir, err := ioutil.TempDir(dir, ".import-*")
+	if err != nil {
+		return "", nil, err
+	}
+	defer func() { _ = os.RemoveAll(tempDir) }()
+
+	rawManifest, manifest, err := extractBundle(bundlePath, tempDir)
+	if err != nil {
+		return "", nil, err
+	}
+	for _, entry := range manifest.Entries {
+		err = entry.verifyFile(filepath.Join(tempDir, filepath.FromSlash(entry.Path)))
+		if err != nil {
+			return "", nil, fmt.Errorf("'%s' is corrupted: %s", entry.Path, err)
+		}
+	}
+
+	unpackedDir := filepath.Join(dir, utils.HashString(string(rawManifest))[:12])
+	lock, err := utils.LockCacheEntry(unpackedDir)
+	if err != nil {
+		return "", nil, err
+	}
+	defer func() { _ = lock.Unlock() }()
+	err = os.RemoveAll(unpackedDir)
+	if err != nil {
+		return "", nil, err
+	}
+	err = os.Rename(tempDir, unpackedDir)
+	if err != nil {
+		return "", nil, err
+	}
+	return unpackedDir, manifest, nil
+}
+
+// extractBundle extracts files listed in the manifest and rejects anything else
+func extractBundle(bundlePath, dir string) ([]byte, *bundleManifestFile, error) {
+	file, err := os.Open(bundlePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() { _ = file.Close() }()
+	compressed, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	archive := tar.NewReader(compressed)
+
+	header, err := archive.Next()
+	if err != nil || header.Name != bundleManifest {
+		return nil, nil, fmt.Errorf("not a bundle: it must start with %s", bundleManifest)
+	}
+	rawManifest, err := ioutil.ReadAll(archive)
+	if err != nil {
+		return nil, nil, err
+	}
+	var manifest bundleManifestFile
+	err = yml.Unmarshal(rawManifest, &manifest)
+	if err != nil {
+		return nil, nil, fmt.Errorf("malformed %s: %s", bundleManifest, err)
+	}
+	expected := make(map[string]*bundleEntry)
+	for _, entry := range manifest.Entries {
+		if !isBundlePath(entry.Path) {
+			return nil, nil, fmt.Errorf("%s lists an unsafe path '%s'", bundleManifest, entry.Path)
+		}
+		if _, ok := expected[entry.Path]; ok {
+			return nil, nil, fmt.Errorf("%s lists '%s' more than once", bundleManifest, entry.Path)
+		}
+		expected[entry.Path] = entry
+	}
+
+	for {
+		header, err = archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if header.Typeflag == tar.TypeDir {
+			continue // dirs are created as needed
+		}
+		entry, ok := expected[header.Name]
+		if !ok || header.Typeflag != tar.TypeReg {
+			return nil, nil, fmt.Errorf("'%s' is not listed in %s", header.Name, bundleManifest)
+		}
+		delete(expected, header.Name) // so that duplicates are rejected
+		err = extractBundleFile(archive, filepath.Join(dir, filepath.FromSlash(entry.Path)))
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	for name := range expected {
+		return nil, nil, fmt.Errorf("'%s' is listed in %s but missing", name, bundleManifest)
+	}
+	return rawManifest, &manifest, nil
+}
+
+// isBundlePath tells whether the path stays within the dir the bundle is extracted to
+func isBundlePath(name string) bool {
+	return name != "" && name == path.Clean(name) && !path.IsAbs(name) && name != "." && name != ".." &&
+		name != bundleManifest && !strings.HasPrefix(name, "../") && !strings.Contains(name, "\\")
+}
+
+func extractBundleFile(source io.Reader, destination string) error {
+	err := os.MkdirAll(filepath.Dir(destination), 0755)
+	if err != nil {
+		return err
+	}
+	target, err := os.OpenFile(destination, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0755)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(target, source)
+	errClose := target.Close()
+	if err != nil {
+		return err
+	}
+	return errClose
+}
+
+func (e *bundleEntry) verifyFile(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.Size() != e.Size {
+		return fmt.Errorf("actual size of %d does not match expected value of %d", info.Size(), e.Size)
+	}
+	if !strings.HasPrefix(e.Digest, "sha256:") {
+		return fmt.Errorf("expected a sha256 digest but got '%s'", e.Digest)
+	}
+	return utils.DigestVerify(path, e.Digest)
+}
+
+// writeBundleIndex lists plugins and tools by their kinds (and in the tools section) just like any other index does
+func writeBundleIndex(indexPath, bundlePath, unpackedDir string, manifest *bundleManifestFile) error {
+	absoluteDir, err := filepath.Abs(unpackedDir)
+	if err != nil {
+		return err
+	}
+	kindToNames := make(map[string]map[string]map[string]map[string]bundleIndexEntry)
+	for _, entry := range manifest.Entries {
+		kind := entry.Kind
+		if kind == bundleKindTool {
+			kind = index.SectionTools
+		}
+		if _, ok := kindToNames[kind]; !ok {
+			kindToNames[kind] = make(map[string]map[string]map[string]bundleIndexEntry)
+		}
+		if _, ok := kindToNames[kind][entry.Name]; !ok {
+			kindToNames[kind][entry.Name] = make(map[string]map[string]bundleIndexEntry)
+		}
+		if _, ok := kindToNames[kind][entry.Name][entry.Version]; !ok {
+			kindToNames[kind][entry.Name][entry.Version] = make(map[string]bundleIndexEntry)
+		}
+		kindToNames[kind][entry.Name][entry.Version][entry.Platform] = bundleIndexEntry{
+			Url:         "file://" + filepath.ToSlash(filepath.Join(absoluteDir, filepath.FromSlash(entry.Path))),
+			Size:        entry.Size,
+			Digest:      entry.Digest,
+			IndexDigest: entry.IndexDigest,
+		}
+	}
+
+	raw, err := yml.Marshal(kindToNames)
+	if err != nil {
+		return err
+	}
+	header := fmt.Sprintf(
+		"# Generated by 'para bundle import' from %s (exported %s from %s)\n",
+		filepath.Base(bundlePath), manifest.Created, manifest.Index,
+	)
+	return utils.WriteFileAtomic(indexPath, append([]byte(header), raw...), 0644)
+}
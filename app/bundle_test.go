@@ -0,0 +1,184 @@
+package app
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"fmt"
+	"github.com/paraterraform/para/app/index"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIsBundlePath(t *testing.T) {
+	cases := []struct {
+		name     string
+		expected bool
+	}{
+		{"plugins/provider/foo/1.0.0/linux_amd64", true},
+		{"tools/terraform/v1.5.0/linux_amd64/terraform", true},
+		{"file", true},
+		{"..file", true},
+		{"", false},
+		{".", false},
+		{"..", false},
+		{"../file", false},
+		{"plugins/../../file", false},
+		{"plugins/./file", false},
+		{"plugins//file", false},
+		{"plugins/", false},
+		{"/etc/passwd", false},
+		{"plugins\\..\\file", false},
+		{bundleManifest, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if actual := isBundlePath(c.name); actual != c.expected {
+				t.Errorf("expected %t, got %t", c.expected, actual)
+			}
+		})
+	}
+}
+
+func TestBundleDuplicates(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "terraform")
+	if err := ioutil.WriteFile(source, []byte("terraform"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	newEntry := func() *bundleEntry {
+		return &bundleEntry{
+			Kind:     bundleKindTool,
+			Name:     "terraform",
+			Version:  "v1.5.0",
+			Platform: "linux_amd64",
+			Path:     "tools/terraform/v1.5.0/linux_amd64/terraform",
+			source:   source,
+		}
+	}
+	entries := []*bundleEntry{newEntry(), newEntry()}
+
+	deduped := dedupeBundleEntries(entries)
+	if len(deduped) != 1 || deduped[0] != entries[0] {
+		t.Fatalf("expected only the first entry to be kept, got %d", len(deduped))
+	}
+	for _, entry := range entries {
+		if err := entry.describeFile(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("deduped", func(t *testing.T) {
+		bundle := filepath.Join(t.TempDir(), "bundle.tgz")
+		err := writeBundle(bundle, &bundleManifestFile{Entries: deduped})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, manifest, err := unpackBundle(bundle, t.TempDir())
+		if err != nil {
+			t.Fatalf("expected the bundle to import, got %s", err)
+		}
+		if len(manifest.Entries) != 1 {
+			t.Errorf("expected 1 entry, got %d", len(manifest.Entries))
+		}
+	})
+
+	t.Run("duplicated", func(t *testing.T) {
+		bundle := filepath.Join(t.TempDir(), "bundle.tgz")
+		err := writeBundle(bundle, &bundleManifestFile{Entries: entries})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _, err = unpackBundle(bundle, t.TempDir())
+		expected := "lists 'tools/terraform/v1.5.0/linux_amd64/terraform' more than once"
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected error containing '%s', got %v", expected, err)
+		}
+	})
+}
+
+// Plugins extracted from archives are bundled as extracted files but lock files record digests of archives
+func TestBundleKeepsLockedDigests(t *testing.T) {
+	dir := t.TempDir()
+	content := []byte("#!/bin/sh\necho foo\n")
+	archive := filepath.Join(dir, "terraform-provider-foo_v1.0.0.zip")
+	file, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zipWriter := zip.NewWriter(file)
+	writer, err := zipWriter.Create("terraform-provider-foo_v1.0.0")
+	if err == nil {
+		_, err = writer.Write(content)
+	}
+	if err == nil {
+		err = zipWriter.Close()
+	}
+	if errClose := file.Close(); err == nil {
+		err = errClose
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawArchive, err := ioutil.ReadFile(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexPath := filepath.Join(dir, "para.idx.yaml")
+	document := fmt.Sprintf(
+		"provider:\n  foo:\n    v1.0.0:\n      %s: {url: \"%s\", size: %d, digest: \"sha256:%x\"}\n",
+		runtimePlatform(), archive, len(content), sha256.Sum256(rawArchive),
+	)
+	if err := ioutil.WriteFile(indexPath, []byte(document), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// export
+	originalIndex, err := index.DiscoverIndex([]string{indexPath}, t.TempDir(), 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plugins := originalIndex.KindToNameToPlugins["provider"]["foo"]
+	lock := index.NewLock(filepath.Join(dir, "para.lock.yaml"))
+	for _, plugin := range plugins {
+		lock.Record(plugin)
+	}
+	entries := cacheBundlePlugins(originalIndex.BuildRuntimeIndex(0), plugins)
+	for _, entry := range entries {
+		if err := entry.describeFile(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bundle := filepath.Join(dir, "bundle.tgz")
+	if err := writeBundle(bundle, &bundleManifestFile{Entries: entries}); err != nil {
+		t.Fatal(err)
+	}
+
+	// import
+	unpackedDir, manifest, err := unpackBundle(bundle, t.TempDir())
+	if err != nil {
+		t.Fatalf("expected the bundle to import, got %s", err)
+	}
+	generatedPath := filepath.Join(dir, "generated.idx.yaml")
+	if err := writeBundleIndex(generatedPath, bundle, unpackedDir, manifest); err != nil {
+		t.Fatal(err)
+	}
+	importedIndex, err := index.DiscoverIndex([]string{generatedPath}, t.TempDir(), 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := importedIndex.ApplyLock(lock, runtimePlatform()); err != nil {
+		t.Fatalf("expected the lock to match the imported bundle, got %s", err)
+	}
+	imported := importedIndex.KindToNameToPlugins["provider"]["foo"]
+	if len(imported) != 1 {
+		t.Fatalf("expected the locked plugin to be served, got %d", len(imported))
+	}
+	for _, result := range prefetchPlugins(importedIndex.BuildRuntimeIndex(0), imported, true) {
+		if result.err != nil {
+			t.Fatalf("expected the bundled plugin to be verified, got %s", result.err)
+		}
+	}
+}
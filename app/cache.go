@@ -0,0 +1,541 @@
+package app
+
+import (
+	"fmt"
+	"github.com/paraterraform/para/app/index"
+	"github.com/paraterraform/para/utils"
+	yml "gopkg.in/ashald/yaml.v2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	cacheEntryPlugin  = "plugin"
+	cacheEntryTool    = "tool"
+	cacheEntryPartial = "partial download"
+
+	cacheTimeFormat = "2006-01-02 15:04"
+)
+
+// cacheEntry is something in the cache dir that is used, verified and removed as a whole - the rest (cached indices,
+// keys, release listings & checksums) is small and refreshed anyway so it's only removed by 'para cache purge'
+type cacheEntry struct {
+	kind     string
+	title    string
+	path     string // file or dir
+	lockPath string // what is locked while the entry is being downloaded
+	size     int64
+	lastUsed time.Time // modification time that Para updates every time it uses the entry
+
+	// where the entry comes from - empty for Terraform & Terragrunt not provided by the index and partial downloads
+	indexKind, name, version, platform string
+}
+
+// scanCache lists all plugins, tools and partial downloads in the cache dir
+func scanCache(cacheDir string) ([]*cacheEntry, error) {
+	var entries []*cacheEntry
+
+	plugins, err := filepath.Glob(filepath.Join(cacheDir, "plugins", "*", "*", "*", "*"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range plugins {
+		info, err := os.Stat(path)
+		if err != nil || info.IsDir() || isCacheAuxiliary(path) {
+			continue
+		}
+		// plugins/<kind>/<name>/<version>/<platform>
+		versionDir := filepath.Dir(path)
+		nameDir := filepath.Dir(versionDir)
+		entry := &cacheEntry{
+			kind:      cacheEntryPlugin,
+			path:      path,
+			lockPath:  path,
+			size:      info.Size(),
+			lastUsed:  info.ModTime(),
+			indexKind: filepath.Base(filepath.Dir(nameDir)),
+			name:      filepath.Base(nameDir),
+			version:   filepath.Base(versionDir),
+			platform:  filepath.Base(path),
+		}
+		entry.title = fmt.Sprintf(
+			"%s '%s' version '%s' for '%s'", entry.indexKind, entry.name, entry.version, entry.platform,
+		)
+		entries = append(entries, entry)
+	}
+
+	toolRoots := []string{filepath.Join(cacheDir, terraformExec), filepath.Join(cacheDir, terragruntExec)}
+	indexTools, err := filepath.Glob(filepath.Join(cacheDir, index.SectionTools, "*"))
+	if err != nil {
+		return nil, err
+	}
+	toolRoots = append(toolRoots, indexTools...)
+	for _, root := range toolRoots {
+		name := filepath.Base(root)
+		fromIndex := filepath.Dir(root) != filepath.Clean(cacheDir)
+		platforms, err := filepath.Glob(filepath.Join(root, "*", "*"))
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range platforms {
+			version := filepath.Base(filepath.Dir(path))
+			if version == "partial" || version == "checksums" {
+				continue
+			}
+			info, err := os.Stat(path)
+			if err != nil || !info.IsDir() || isCacheAuxiliaryDir(path) {
+				continue
+			}
+			executable := filepath.Join(path, name)
+			entry := &cacheEntry{
+				kind:     cacheEntryTool,
+				title:    fmt.Sprintf("%s version '%s' for '%s'", name, version, filepath.Base(path)),
+				path:     path,
+				lockPath: executable,
+				size:     pathSize(path),
+				lastUsed: info.ModTime(),
+				name:     name,
+				version:  version,
+				platform: filepath.Base(path),
+			}
+			if executableInfo, err := os.Stat(executable); err == nil {
+				entry.lastUsed = executableInfo.ModTime()
+			}
+			if fromIndex {
+				entry.indexKind = index.SectionTools
+			}
+			entries = append(entries, entry)
+		}
+	}
+
+	partialDirs := []string{filepath.Join(cacheDir, "partial")}
+	for _, root := range toolRoots {
+		partialDirs = append(partialDirs, filepath.Join(root, "partial"))
+	}
+	for _, dir := range partialDirs {
+		parts, err := filepath.Glob(filepath.Join(dir, "*.part"))
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range parts {
+			info, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+			entries = append(entries, &cacheEntry{
+				kind:     cacheEntryPartial,
+				title:    fmt.Sprintf("download of '%s'", partialDownloadUrl(path)),
+				path:     path,
+				lockPath: path,
+				size:     pathSize(path) + pathSize(path+".yaml"),
+				lastUsed: info.ModTime(),
+			})
+		}
+	}
+
+	return entries, nil
+}
+
+// isCacheAuxiliary tells whether the file is a lock or a temp file rather than a cache entry
+func isCacheAuxiliary(path string) bool {
+	return strings.HasSuffix(path, ".lock") || strings.HasPrefix(filepath.Base(path), ".")
+}
+
+// isCacheAuxiliaryDir tells whether the dir only has locks or temp files left (e.g. once the entry was removed)
+func isCacheAuxiliaryDir(path string) bool {
+	children, err := ioutil.ReadDir(path)
+	if err != nil {
+		return false
+	}
+	for _, child := range children {
+		if !isCacheAuxiliary(child.Name()) {
+			return false
+		}
+	}
+	return true
+}
+
+// partialDownloadUrl reads the URL a partial download is for from its metadata
+func partialDownloadUrl(path string) string {
+	var meta struct {
+		Url string `yaml:"url"`
+	}
+	raw, err := ioutil.ReadFile(path + ".yaml")
+	if err == nil {
+		_ = yml.Unmarshal(raw, &meta)
+	}
+	if meta.Url == "" {
+		return filepath.Base(path)
+	}
+	return utils.UrlRedact(meta.Url)
+}
+
+// pathSize is the total size of a file or all files within a dir
+func pathSize(path string) int64 {
+	var total int64
+	_ = filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			total += info.Size()
+		}
+		return nil
+	})
+	return total
+}
+
+// touchCacheEntry records that the entry was just used
+func touchCacheEntry(path string) {
+	now := time.Now()
+	_ = os.Chtimes(path, now, now)
+}
+
+// remove waits until nobody downloads the entry and removes it along with empty parent dirs. The lock file is kept:
+// others may be waiting on it already and if it was removed the next process would lock a new file at the same path
+// while they still hold the old one.
+func (e *cacheEntry) remove(cacheDir string) error {
+	lock, err := utils.LockCacheEntry(e.lockPath)
+	if err != nil {
+		return err
+	}
+	err = removeExceptLocks(e.path)
+	if err == nil && e.kind == cacheEntryPartial {
+		err = os.Remove(e.path + ".yaml")
+		if os.IsNotExist(err) {
+			err = nil
+		}
+	}
+	_ = lock.Unlock() // whoever waits for it will just download the entry once again
+	if err != nil {
+		return err
+	}
+
+	for dir := filepath.Dir(e.path); strings.HasPrefix(dir, filepath.Clean(cacheDir)+string(filepath.Separator)); {
+		if os.Remove(dir) != nil {
+			break // not empty
+		}
+		dir = filepath.Dir(dir)
+	}
+	return nil
+}
+
+// removeExceptLocks removes the file or everything within the dir but lock files
+func removeExceptLocks(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil || !info.IsDir() {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return os.Remove(path)
+	}
+	children, err := ioutil.ReadDir(path)
+	if err != nil {
+		return err
+	}
+	for _, child := range children {
+		if strings.HasSuffix(child.Name(), ".lock") {
+			continue
+		}
+		err = os.RemoveAll(filepath.Join(path, child.Name()))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isListed tells whether the entry is still present in the index - entries that don't come from it always are
+func (e *cacheEntry) isListed(loadingIndex *index.LoadingIndex) bool {
+	return e.findInIndex(loadingIndex) != nil || e.indexKind == ""
+}
+
+func (e *cacheEntry) findInIndex(loadingIndex *index.LoadingIndex) *index.Plugin {
+	var candidates []*index.Plugin
+	switch {
+	case e.kind == cacheEntryPlugin:
+		candidates = loadingIndex.KindToNameToPlugins[e.indexKind][e.name]
+	case e.kind == cacheEntryTool && e.indexKind != "":
+		candidates = loadingIndex.NameToTools[e.name]
+	}
+	for _, p := range candidates {
+		version := p.Version
+		if e.kind == cacheEntryTool {
+			version = "v" + p.SemVer.Core() // that's how index tools are named in the cache
+		}
+		if version == e.version && p.Platform == e.platform {
+			return p
+		}
+	}
+	return nil
+}
+
+// verify checks that the entry is intact: plugins against size and digest (unless they were extracted from archives)
+// in the index, tools against digests recorded when they were downloaded
+func (e *cacheEntry) verify(loadingIndex *index.LoadingIndex) error {
+	switch e.kind {
+	case cacheEntryPlugin:
+		plugin := e.findInIndex(loadingIndex)
+		if plugin == nil {
+			return nil // nothing to verify against
+		}
+		return verifyCachedPlugin(e.path, e.size, plugin)
+	case cacheEntryTool:
+		if !isVerifiedExecutable(filepath.Join(e.path, e.name), true) {
+			return fmt.Errorf("executable does not match the digest recorded when it was downloaded")
+		}
+		if tool := e.findInIndex(loadingIndex); tool != nil && tool.Size > 0 {
+			size := pathSize(filepath.Join(e.path, e.name))
+			if uint64(size) != tool.Size {
+				return fmt.Errorf("actual size of %d does not match expected value of %d", size, tool.Size)
+			}
+		}
+	}
+	return nil
+}
+
+// verifyCachedPlugin checks the plugin against size and digest in the index (unless it was extracted from an archive)
+func verifyCachedPlugin(path string, size int64, plugin *index.Plugin) error {
+	if uint64(size) != plugin.Size {
+		return fmt.Errorf("actual size of %d does not match expected value of %d", size, plugin.Size)
+	}
+	if plugin.Digest != "" && !(utils.DownloadableFile{Url: plugin.Url, ExtractPattern: "terraform-*"}).IsArchive() {
+		return utils.DigestVerify(path, plugin.Digest)
+	}
+	return nil
+}
+
+func (e *cacheEntry) describe() string {
+	return fmt.Sprintf("%s (%s, used %s)", e.title, utils.SizeFormat(e.size), e.lastUsed.Format(cacheTimeFormat))
+}
+
+// pruneCache removes least recently used entries until the cache dir fits the given size
+func pruneCache(cacheDir string, entries []*cacheEntry, maxSize int64) ([]*cacheEntry, error) {
+	sort.SliceStable(entries, func(a, b int) bool {
+		return entries[a].lastUsed.Before(entries[b].lastUsed)
+	})
+	total := pathSize(cacheDir)
+	var removed []*cacheEntry
+	for _, entry := range entries {
+		if total <= maxSize {
+			break
+		}
+		err := entry.remove(cacheDir)
+		if err != nil {
+			return removed, err
+		}
+		total -= entry.size
+		removed = append(removed, entry)
+	}
+	return removed, nil
+}
+
+// enforceCacheLimit prunes the cache dir at startup if it's limited and grew too big, returns what's to be reported
+func enforceCacheLimit(cacheDir string, maxSize int64) string {
+	if maxSize <= 0 {
+		return ""
+	}
+	entries, err := scanCache(cacheDir)
+	if err == nil {
+		var removed []*cacheEntry
+		removed, err = pruneCache(cacheDir, entries, maxSize)
+		if len(removed) > 0 {
+			return fmt.Sprintf(
+				"pruned %d least recently used entries to fit %s", len(removed), utils.SizeFormat(maxSize),
+			)
+		}
+	}
+	if err != nil {
+		return fmt.Sprintf("failed to fit %s: %s", utils.SizeFormat(maxSize), err)
+	}
+	return ""
+}
+
+// openCacheDir discovers the cache dir and prints it as the first line of the summary
+func openCacheDir(customCachePath string) string {
+	fmt.Printf("- Cache Dir: ")
+	cacheDir, err := discoverCacheDir(customCachePath)
+	if err != nil {
+		fmt.Printf(
+			"\n* Error: Para requires a writable cache dir for operation but failed discovering one: %s\n",
+			err,
+		)
+		os.Exit(1)
+	}
+	fmt.Println(utils.PathSimplify(cacheDir))
+	return cacheDir
+}
+
+func scanCacheOrExit(cacheDir string) []*cacheEntry {
+	entries, err := scanCache(cacheDir)
+	if err != nil {
+		fmt.Printf("* Error: cannot scan cache dir: %s\n", err)
+		os.Exit(1)
+	}
+	return entries
+}
+
+// loadIndexForCache loads the index as is - without pins and lock file that would hide some of the versions
+func loadIndexForCache(indexConfig IndexConfig, cacheDir string, refresh time.Duration) *index.LoadingIndex {
+	indexConfig.Pins = nil
+	loadingIndex, _, err := loadIndex(indexConfig, cacheDir, refresh, false)
+	if err != nil {
+		fmt.Printf("\n* Error: %s\n", err)
+		os.Exit(1)
+	}
+	return loadingIndex
+}
+
+// CacheList lists plugins, tools and partial downloads in the cache dir with their sizes and last use times
+func CacheList(customCachePath string) {
+	cacheDir := openCacheDir(customCachePath)
+	entries := scanCacheOrExit(cacheDir)
+	sort.SliceStable(entries, func(a, b int) bool {
+		return entries[a].title < entries[b].title
+	})
+
+	var totalEntries int64
+	for _, section := range []struct{ kind, title string }{
+		{cacheEntryPlugin, "Plugins"}, {cacheEntryTool, "Tools"}, {cacheEntryPartial, "Partial Downloads"},
+	} {
+		var lines []string
+		var size int64
+		for _, entry := range entries {
+			if entry.kind == section.kind {
+				lines = append(lines, entry.describe())
+				size += entry.size
+			}
+		}
+		totalEntries += size
+		fmt.Printf("- %s: %d (%s)\n", section.title, len(lines), utils.SizeFormat(size))
+		for _, line := range lines {
+			fmt.Printf("  * %s\n", line)
+		}
+	}
+
+	total := pathSize(cacheDir)
+	fmt.Printf(
+		"- Total: %s (including %s of cached indices, keys and release listings)\n",
+		utils.SizeFormat(total), utils.SizeFormat(total-totalEntries),
+	)
+}
+
+// CacheVerify re-verifies cached plugins and tools and removes corrupted ones so that they are downloaded once again
+func CacheVerify(indexConfig IndexConfig, customCachePath string, refresh time.Duration) {
+	cacheDir := openCacheDir(customCachePath)
+	loadingIndex := loadIndexForCache(indexConfig, cacheDir, refresh)
+
+	var ok, unlisted int
+	var problems []string
+	for _, entry := range scanCacheOrExit(cacheDir) {
+		if entry.kind == cacheEntryPartial {
+			continue // verified once complete
+		}
+		err := entry.verify(loadingIndex)
+		if err == nil {
+			ok += 1
+			if !entry.isListed(loadingIndex) {
+				unlisted += 1
+			}
+			continue
+		}
+		errRemove := entry.remove(cacheDir)
+		if errRemove != nil {
+			problems = append(problems, fmt.Sprintf(
+				"Error: %s is corrupted (%s) but cannot be removed: %s", entry.title, err, errRemove,
+			))
+		} else {
+			problems = append(problems, fmt.Sprintf("Removed %s: %s", entry.title, err))
+		}
+	}
+
+	fmt.Printf(
+		"- Verification: %d intact (%d of them no longer in the index), %d corrupted (removed)\n",
+		ok, unlisted, len(problems),
+	)
+	for _, problem := range problems {
+		fmt.Printf("  * %s\n", problem)
+	}
+	if unlisted > 0 {
+		fmt.Println("  * Use 'para cache prune --unlisted' to remove entries that are no longer in the index")
+	}
+}
+
+// CachePrune removes entries that are no longer in the index, that were not used for a while and least recently used
+// ones to fit the size budget - in that order, if requested
+func CachePrune(
+	indexConfig IndexConfig, customCachePath string, refresh time.Duration,
+	unlisted bool, olderThan time.Duration, maxSize int64,
+) {
+	if !unlisted && olderThan <= 0 && maxSize <= 0 {
+		fmt.Println("* Error: nothing to prune by - use --unlisted, --older-than or --max-size (or cache-max-size)")
+		os.Exit(1)
+	}
+
+	cacheDir := openCacheDir(customCachePath)
+	var loadingIndex *index.LoadingIndex
+	if unlisted {
+		loadingIndex = loadIndexForCache(indexConfig, cacheDir, refresh)
+	}
+
+	var removed []*cacheEntry
+	var kept []*cacheEntry
+	removeOrExit := func(entry *cacheEntry) {
+		err := entry.remove(cacheDir)
+		if err != nil {
+			fmt.Printf("* Error: cannot remove %s: %s\n", entry.title, err)
+			os.Exit(1)
+		}
+		removed = append(removed, entry)
+	}
+	for _, entry := range scanCacheOrExit(cacheDir) {
+		switch {
+		case unlisted && !entry.isListed(loadingIndex):
+			removeOrExit(entry)
+		case olderThan > 0 && entry.lastUsed.Before(time.Now().Add(-olderThan)):
+			removeOrExit(entry)
+		default:
+			kept = append(kept, entry)
+		}
+	}
+	if maxSize > 0 {
+		lru, err := pruneCache(cacheDir, kept, maxSize)
+		removed = append(removed, lru...)
+		if err != nil {
+			fmt.Printf("* Error: cannot fit the cache dir into %s: %s\n", utils.SizeFormat(maxSize), err)
+			os.Exit(1)
+		}
+	}
+
+	var freed int64
+	for _, entry := range removed {
+		freed += entry.size
+	}
+	fmt.Printf(
+		"- Pruned: %d entries (%s freed, %s left)\n",
+		len(removed), utils.SizeFormat(freed), utils.SizeFormat(pathSize(cacheDir)),
+	)
+	for _, entry := range removed {
+		fmt.Printf("  * %s\n", entry.describe())
+	}
+}
+
+// CachePurge removes everything from the cache dir
+func CachePurge(customCachePath string) {
+	cacheDir := openCacheDir(customCachePath)
+	size := pathSize(cacheDir)
+	children, err := ioutil.ReadDir(cacheDir)
+	if err != nil {
+		fmt.Printf("* Error: cannot read cache dir: %s\n", err)
+		os.Exit(1)
+	}
+	for _, child := range children {
+		err = os.RemoveAll(filepath.Join(cacheDir, child.Name()))
+		if err != nil {
+			fmt.Printf("* Error: cannot purge cache dir: %s\n", err)
+			os.Exit(1)
+		}
+	}
+	fmt.Printf("- Purged: %s freed\n", utils.SizeFormat(size))
+}
@@ -0,0 +1,59 @@
+package app
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCacheEntryRemove(t *testing.T) {
+	cacheDir := t.TempDir()
+	files := []string{
+		"plugins/provider/foo/1.0.0/linux_amd64",
+		"plugins/provider/foo/1.0.0/linux_amd64.lock",
+		"terraform/1.5.0/linux_amd64/terraform",
+		"terraform/1.5.0/linux_amd64/terraform.lock",
+		"partial/abc.part",
+		"partial/abc.part.yaml",
+		"partial/abc.part.lock",
+	}
+	for _, file := range files {
+		path := filepath.Join(cacheDir, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte("content"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := scanCache(cacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(entries))
+	}
+	for _, entry := range entries {
+		err = entry.remove(cacheDir)
+		if err != nil {
+			t.Fatalf("cannot remove %s: %s", entry.title, err)
+		}
+	}
+
+	for _, file := range files {
+		_, err := os.Stat(filepath.Join(cacheDir, file))
+		if exists, isLock := err == nil, filepath.Ext(file) == ".lock"; exists != isLock {
+			t.Errorf("expected '%s' to exist: %t, got %t", file, isLock, exists)
+		}
+	}
+
+	entries, err = scanCache(cacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		t.Errorf("expected no entries once removed, got %s", entry.title)
+	}
+}
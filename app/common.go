@@ -1,20 +1,183 @@
 package app
 
 import (
+	"fmt"
 	"github.com/paraterraform/para/utils"
-	"path/filepath"
+	yml "gopkg.in/ashald/yaml.v2"
+	"io/ioutil"
+	"os"
 	"strings"
 	"time"
 )
 
-func findChecksumForFile(prefix, url, file, cache string, refresh time.Duration) string {
-	checksums, _, err := utils.DownloadableFile{
-		Url: url,
-	}.ReadAllWithCache(filepath.Join(cache, "checksums"), refresh)
+const (
+	pgpInlineKeyPrefix = "-----BEGIN PGP"
+
+	verifiedSuffix    = ".verified.yaml"
+	verifiedDigestAlg = "sha256"
+)
+
+// VerificationError means that a downloaded artifact cannot be verified because a checksum or a signature is missing
+type VerificationError struct {
+	Url      string // checksums or signature that should have been used for verification
+	Artifact string
+	Reason   error
+}
+
+func (e *VerificationError) Error() string {
+	return fmt.Sprintf("cannot verify '%s' with '%s': %s", e.Artifact, e.Url, e.Reason)
+}
+
+// verifiedExecutable is stored next to a cached executable and records what it was verified against
+type verifiedExecutable struct {
+	Url        string `yaml:"url"`
+	Digest     string `yaml:"digest"`     // published digest of the download (empty if it was installed unverified)
+	Executable string `yaml:"executable"` // digest of the executable itself so that it can be re-verified later
+}
+
+// ToolsConfig describes which versions of tools (such as Terraform and Terragrunt) Para downloads and how it verifies them
+type ToolsConfig struct {
+	TerraformVersion    string
+	TerraformPrerelease bool // consider pre-releases when resolving Terraform version
+	TerragruntVersion   string
+	TerraformKeys       []string // paths/URLs to (or inline) OpenPGP public keys trusted to sign Terraform releases
+	Insecure            bool     // install tools even if their signatures or checksums are missing
+
+	Versions map[string]string // required versions of other tools (listed in the index) by their names
+
+	// Mirrors (empty means upstream), file:// dirs are fine as long as they are laid out the same way as upstream
+	TerraformReleases   string // where index.json and checksums are
+	TerraformDownloads  string // where archives are (defaults to TerraformReleases)
+	TerragruntReleases  string // GitHub releases API or a dir with releases
+	TerragruntDownloads string // where executables and checksums are
+}
+
+func (c ToolsConfig) terraformReleases() string {
+	return firstNonEmpty(c.TerraformReleases, terraformReleases)
+}
+
+func (c ToolsConfig) terraformDownloads() string {
+	return firstNonEmpty(c.TerraformDownloads, c.terraformReleases())
+}
+
+func (c ToolsConfig) terragruntReleases() string {
+	return firstNonEmpty(c.TerragruntReleases, terragruntReleases)
+}
+
+func (c ToolsConfig) terragruntDownloads() string {
+	return firstNonEmpty(c.TerragruntDownloads, terragruntDownload)
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// downloadedTool describes an executable Para downloaded (or found in cache)
+type downloadedTool struct {
+	Dir          string
+	Version      string
+	Verification string // empty if it was found in cache
+}
+
+// isVerifiedExecutable tells whether a cached executable is still the one that was verified when it was downloaded
+func isVerifiedExecutable(path string, insecure bool) bool {
+	raw, err := ioutil.ReadFile(path + verifiedSuffix)
 	if err != nil {
-		return ""
+		return false
+	}
+	var record verifiedExecutable
+	err = yml.UnmarshalStrict(raw, &record)
+	if err != nil || record.Executable == "" {
+		return false
 	}
+	if record.Digest == "" && !insecure {
+		return false // it was installed unverified so let's try to get a verified one
+	}
+	return utils.DigestVerify(path, record.Executable) == nil
+}
+
+// saveVerifiedExecutable downloads an executable (verifying its digest and size if they are known) and records it in the
+// cache
+func saveVerifiedExecutable(file utils.DownloadableFile, path string, size uint64) error {
+	_ = os.Remove(path + verifiedSuffix)
+
+	err := file.SaveTo(path)
+	if err != nil {
+		return err
+	}
+	if size > 0 {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if uint64(info.Size()) != size {
+			_ = os.Remove(path)
+			return fmt.Errorf(
+				"actual size of '%s' of %d does not match expected value of %d", file.Url, info.Size(), size,
+			)
+		}
+	}
+
+	digest, err := utils.DigestCompute(path, verifiedDigestAlg)
+	if err != nil {
+		return err
+	}
+	raw, err := yml.Marshal(verifiedExecutable{Url: file.Url, Digest: file.Digest, Executable: digest})
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(path+verifiedSuffix, raw, 0644)
+}
+
+// describeVerification summarizes how a downloaded executable was verified for the user
+func describeVerification(problems []error, digest, signer string) string {
+	var verification string
+	switch {
+	case len(problems) == 0 && signer != "":
+		return "signed by " + signer
+	case len(problems) == 0:
+		return "checksum verified"
+	case digest != "":
+		verification = "checksum only, NOT signed"
+	default:
+		verification = "NOT verified"
+	}
+	var reasons []string
+	for _, problem := range problems {
+		reasons = append(reasons, problem.Error())
+	}
+	return verification + ": " + strings.Join(reasons, "; ")
+}
+
+// loadKeyRing builds a key ring out of paths/URLs to (or inline) armored or binary OpenPGP public keys
+func loadKeyRing(keys []string, cacheDir string, refresh time.Duration) (*utils.PgpKeyRing, error) {
+	keyRing := &utils.PgpKeyRing{}
+	for _, key := range keys {
+		var err error
+		raw := []byte(key)
+		if !strings.HasPrefix(strings.TrimSpace(key), pgpInlineKeyPrefix) {
+			raw, _, err = utils.DownloadableFile{Url: key}.ReadAllWithCache(cacheDir, refresh)
+			if err != nil {
+				return nil, fmt.Errorf("cannot read trusted key '%s': %s", key, err)
+			}
+		} else {
+			key = "<inline>"
+		}
+		err = keyRing.Add(raw)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load trusted key '%s': %s", key, err)
+		}
+	}
+	return keyRing, nil
+}
 
+// findChecksumForFile returns a digest (with the given prefix) for the file from checksums published at the given URL
+func findChecksumForFile(prefix, url string, checksums []byte, file string) (string, error) {
 	for _, line := range strings.Split(string(checksums), "\n") {
 		fields := strings.Fields(line)
 		if len(fields) != 2 {
@@ -23,9 +186,16 @@ func findChecksumForFile(prefix, url, file, cache string, refresh time.Duration)
 		lineHash := fields[0]
 		lineName := fields[1]
 		if lineName == file {
-			return prefix + lineHash
+			return prefix + lineHash, nil
 		}
 
 	}
-	return ""
+	return "", &VerificationError{Url: url, Artifact: file, Reason: fmt.Errorf("no checksum found for the artifact")}
+}
+
+// printVerificationHint explains how to proceed when a download cannot be verified
+func printVerificationHint(err error) {
+	if _, ok := err.(*VerificationError); ok {
+		fmt.Println("  Use --insecure (or 'insecure: true' in config file) to install it anyway at your own risk.")
+	}
 }
@@ -4,7 +4,6 @@ import (
 	"bazil.org/fuse"
 	"bazil.org/fuse/fs"
 	"fmt"
-	"github.com/mitchellh/go-homedir"
 	"github.com/paraterraform/para/app/index"
 	"github.com/paraterraform/para/utils"
 	"io/ioutil"
@@ -12,7 +11,6 @@ import (
 	"os/exec"
 	"os/signal"
 	"path/filepath"
-	"sort"
 	"strconv"
 	"strings"
 	"syscall"
@@ -31,16 +29,22 @@ var pluginDirCandidates = []string{
 
 func Execute(
 	args []string,
-	primaryIndexCandidates, indexExtensions []string,
-	customCachePath string, refresh time.Duration,
-	versionTerraform string,
-	versionTerragrunt string,
+	indexConfig IndexConfig,
+	customCachePath string, refresh time.Duration, cacheMaxSize int64,
+	pluginDirMode string,
+	toolsConfig ToolsConfig,
 ) {
 	var pluginDir string
 	var mountpoint *string
 	var stat os.FileInfo
 	var err error
 
+	err = checkPluginDirMode(pluginDirMode)
+	if err != nil {
+		fmt.Printf("* Error: %s\n", err)
+		os.Exit(1)
+	}
+
 	// Cache Dir
 	fmt.Printf("- Cache Dir: ")
 	cacheDir, err := discoverCacheDir(customCachePath)
@@ -52,48 +56,30 @@ func Execute(
 		os.Exit(1)
 	}
 	fmt.Println(utils.PathSimplify(cacheDir))
+	if pruned := enforceCacheLimit(cacheDir, cacheMaxSize); pruned != "" {
+		fmt.Printf("  * %s\n", pruned)
+	}
 
-	cmd := args[0]
-	if cmd == terraformExec || cmd == terragruntExec {
-		fmt.Printf("- Terraform: ")
-		terraformExisting, err := exec.LookPath(terraformExec)
-		if err != nil {
-			// No terraform - need to download it
-			fmt.Print("downloading")
-			terraformDir, err := downloadTerraform(versionTerraform, cacheDir, refresh)
-			if err != nil {
-				fmt.Printf("\n* Error: Para was unable to download Terraform: %s\n", err)
-				os.Exit(1)
-			}
-			err = appendToPath(terraformDir)
-			if err != nil {
-				fmt.Printf("\n* Error: Para was unable to add Terraform to $PATH: %s\n", err)
-				os.Exit(1)
-			}
-			fmt.Printf(" to %s\n", utils.PathSimplify(terraformDir))
-		} else {
-			fmt.Printf("found at %s\n", utils.PathSimplify(terraformExisting))
-		}
+	workDir, err := os.Getwd()
+	if err != nil {
+		fmt.Printf("* Error: Para was unable to determine current working dir: %s\n", err)
+		os.Exit(1)
 	}
+
+	loadingIndex, lock, err := loadIndex(indexConfig, cacheDir, refresh, true)
+	if err != nil {
+		fmt.Printf("\n* Error: %s\n", err)
+		os.Exit(1)
+	}
+
+	cmd := args[0]
+	toolNames := []string{cmd}
 	if cmd == terragruntExec {
-		terragruntExisting, err := exec.LookPath(terragruntExec)
-		fmt.Printf("- Terrragrunt: ")
-		if err != nil {
-			// No terragrunt - need to download it
-			fmt.Print("downloading")
-			terragruntDir, err := downloadTerragrunt(versionTerragrunt, cacheDir, refresh)
-			if err != nil {
-				fmt.Printf("\n* Error: Para was unable to download Terragrunt: %s\n", err)
-				os.Exit(1)
-			}
-			err = appendToPath(terragruntDir)
-			if err != nil {
-				fmt.Printf("\n* Error: Para was unable to add Terragrunt to $PATH: %s\n", err)
-				os.Exit(1)
-			}
-			fmt.Printf(" to %s\n", utils.PathSimplify(terragruntDir))
-		} else {
-			fmt.Printf("found at %s\n", utils.PathSimplify(terragruntExisting))
+		toolNames = []string{terraformExec, terragruntExec} // Terragrunt is useless without Terraform
+	}
+	for _, name := range toolNames {
+		if t := findTool(name, toolsConfig, loadingIndex, cacheDir, refresh); t != nil {
+			provideTool(name, t, toolsConfig, workDir)
 		}
 	}
 
@@ -175,39 +161,16 @@ func Execute(
 	_, _ = pidFile.WriteString(fmt.Sprintln(os.Getpid()))
 	_ = pidFile.Sync()
 
-	// Primary Index
-	fmt.Printf("- Primary Index: ")
-	loadingIndex, err := index.DiscoverIndex(primaryIndexCandidates, cacheDir, refresh)
+	// Plugins
+	runtimeIndex := loadingIndex.BuildRuntimeIndex(indexConfig.ParallelDownloads)
+	pluginDirMode, releasePluginDir, err := providePluginDir(
+		runtimeIndex, indexConfig.Pins, lock, *mountpoint, pluginDirMode,
+	)
 	if err != nil {
-		fmt.Printf("\n* Error: cannnot decode primary index as a valid YAML map: %s\n", err)
+		fmt.Printf("\n* Error: %s\n", err)
 		_ = os.Remove(pidFilePath) // defer not guaranteed to run so we manually call it everywhere we need it
 		os.Exit(1)
 	}
-	var indexStats []string
-	for kind, nameToPlugins := range loadingIndex.KindToNameToPlugins {
-		indexStats = append(indexStats, fmt.Sprintf("%ss: %d", kind, len(nameToPlugins)))
-	}
-	sort.Strings(indexStats)
-	fmt.Printf(
-		"%s as of %s (%s)\n",
-		loadingIndex.Location,
-		loadingIndex.Timestamp.Format(time.RFC3339),
-		strings.Join(indexStats, ", "),
-	)
-
-	// Index Extensions
-	fmt.Printf("- Index Extensions: ")
-	loadedExtensions, failedExtensions := loadExtensions(loadingIndex, indexExtensions)
-	var extensionsStats []string
-	for _, ext := range indexExtensions {
-		countLoaded := loadedExtensions[ext]
-		countFailed := failedExtensions[ext]
-		extensionsStats = append(
-			extensionsStats,
-			fmt.Sprintf("%s (%d/%d)", ext, countLoaded, countLoaded+countFailed),
-		)
-	}
-	fmt.Printf("%s\n", strings.Join(extensionsStats, ", "))
 
 	// Command
 	fmt.Printf("- Command: %s\n", strings.Join(args, " "))
@@ -217,15 +180,6 @@ func Execute(
 	fmt.Println(strings.Repeat("-", 72))
 	fmt.Println()
 
-	// init fuse
-	ready, err := mountPluginsDir(loadingIndex.BuildRuntimeIndex(), *mountpoint)
-	if err != nil {
-		fmt.Printf("* Para was unable to mount plugin FS over '%s': %s", pluginDir, err)
-		_ = os.Remove(pidFilePath) // defer not guaranteed to run so we manually call it everywhere we need it
-		os.Exit(1)
-	}
-	<-ready
-
 	// Init sub-process
 	subprocess := exec.Command(cmd, args[1:]...)
 	subprocess.Stdin = os.Stdin
@@ -235,8 +189,8 @@ func Execute(
 	err = subprocess.Start()
 	if err != nil {
 		fmt.Printf("\n* Error: start subprocess: %s\n", err)
-		_ = fuse.Unmount(*mountpoint) // defer not guaranteed to run so we manually call it everywhere we need it
-		_ = os.Remove(pidFilePath)    // defer not guaranteed to run so we manually call it everywhere we need it
+		releasePluginDir()         // defer not guaranteed to run so we manually call it everywhere we need it
+		_ = os.Remove(pidFilePath) // defer not guaranteed to run so we manually call it everywhere we need it
 		os.Exit(1)
 	}
 
@@ -247,8 +201,13 @@ func Execute(
 
 	err = subprocess.Wait()
 
-	_ = fuse.Unmount(*mountpoint) // defer not guaranteed to run so we manually call it everywhere we need it
-	_ = os.Remove(pidFilePath)    // defer not guaranteed to run so we manually call it everywhere we need it
+	releasePluginDir()         // defer not guaranteed to run so we manually call it everywhere we need it
+	_ = os.Remove(pidFilePath) // defer not guaranteed to run so we manually call it everywhere we need it
+
+	if err == nil && pluginDirMode == PluginDirModeFuse {
+		// only FUSE knows which plugins Terraform actually used
+		updateLock(lock, runtimeIndex.ListServedPlugins())
+	}
 
 	if err != nil {
 		if exiterr, ok := err.(*exec.ExitError); ok {
@@ -265,32 +224,50 @@ func Execute(
 	}
 }
 
-func loadExtensions(index *index.LoadingIndex, extensions []string) (loaded map[string]uint64, failed map[string]uint64) {
-	loaded = make(map[string]uint64)
-	failed = make(map[string]uint64)
-
-	for idx := len(extensions) - 1; idx >= 0; idx-- {
-		path := extensions[idx]
-		expandedPath, err := homedir.Expand(path)
-		if err != nil {
-			continue
-		}
-		matches, _ := ioutil.ReadDir(expandedPath)
-		for _, ext := range matches {
-			if ext.IsDir() { // TODO trace
-				failed[path] += 1
-				continue
-			}
+// provideTool makes sure the required version of the tool takes precedence on $PATH (downloading it unless the one
+// already found there satisfies the requirement) or that it's at least available there if there is no requirement
+func provideTool(name string, t tool, config ToolsConfig, workDir string) {
+	title := toolTitle(name)
+	fmt.Printf("- %s: ", title)
+	requirement, err := toolRequirement(name, config, workDir)
+	if err != nil {
+		fmt.Printf("\n* Error: Para was unable to determine required %s version: %s\n", title, err)
+		os.Exit(1)
+	}
+	existing, err := exec.LookPath(name)
+	if err == nil && requirement == nil {
+		fmt.Printf("found at %s\n", utils.PathSimplify(existing))
+		return
+	}
 
-			err := index.LoadExtension(filepath.Join(expandedPath, ext.Name()))
-			if err != nil {
-				failed[path] += 1
-			} else {
-				loaded[path] += 1
+	if requirement != nil {
+		fmt.Printf("'%s' (%s), ", requirement, requirement.Source)
+		if err == nil {
+			if version := existingToolVersion(name, existing); version != nil && requirement.satisfiedBy(version) {
+				fmt.Printf("found %s at %s\n", version, utils.PathSimplify(existing))
+				return
 			}
 		}
 	}
-	return
+
+	// No executable or it doesn't satisfy the requirement - need to download it
+	fmt.Print("downloading")
+	downloaded, err := downloadTool(t, requirement, runtimePlatform(), config.Insecure)
+	if err != nil {
+		fmt.Printf("\n* Error: Para was unable to download %s: %s\n", title, err)
+		printVerificationHint(err)
+		os.Exit(1)
+	}
+	err = prependToPath(downloaded.Dir)
+	if err != nil {
+		fmt.Printf("\n* Error: Para was unable to add %s to $PATH: %s\n", title, err)
+		os.Exit(1)
+	}
+	fmt.Printf(" %s to %s", downloaded.Version, utils.PathSimplify(downloaded.Dir))
+	if downloaded.Verification != "" {
+		fmt.Printf(" (%s)", downloaded.Verification)
+	}
+	fmt.Println()
 }
 
 func discoverCacheDir(customPath string) (string, error) {
@@ -351,10 +328,11 @@ func fuseRun(index *index.RuntimeIndex, c *fuse.Conn) {
 	}
 }
 
-func appendToPath(new string) error {
+// prependToPath makes sure executables downloaded by Para take precedence over ones found elsewhere
+func prependToPath(new string) error {
 	name := "PATH"
 	current, _ := os.LookupEnv(name)
-	value := strings.Join(append(strings.Split(current, ":"), new), ":")
+	value := strings.Join(append([]string{new}, strings.Split(current, ":")...), ":")
 	return os.Setenv(name, value)
 }
 
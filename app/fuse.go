@@ -5,6 +5,7 @@ import (
 	"bazil.org/fuse/fs"
 	"github.com/paraterraform/para/app/index"
 	"golang.org/x/net/context"
+	"io"
 	"os"
 )
 
@@ -87,7 +88,11 @@ func (f File) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadRe
 		return err
 	}
 
+	// waits for the data if the plugin is still being downloaded
 	bytesRead, err := reader.ReadAt(dst, req.Offset)
+	if err != nil && err != io.EOF {
+		return fuse.EIO // most likely the download failed or the digest didn't match
+	}
 	resp.Data = dst[:bytesRead]
 
 	return nil
@@ -0,0 +1,273 @@
+package app
+
+import (
+	"fmt"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/paraterraform/para/app/index"
+	"github.com/paraterraform/para/utils"
+	"github.com/zclconf/go-cty/cty"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	pluginKindProvider = "provider"
+
+	// this is what Terraform assumes for providers without an explicit source
+	defaultProviderSourcePrefix = "registry.terraform.io/hashicorp/"
+
+	terraformLockHeader = `# This file is maintained automatically by "terraform init".
+# Manual edits may be lost in future updates.
+`
+)
+
+type terraformLockProvider struct {
+	Address string
+	Version string
+	Hashes  []string
+}
+
+// Hashes computes Terraform package hashes (h1: and zh:) for locked providers and optionally merges them into
+// Terraform's dependency lock file so that Terraform 0.14+ can verify plugins served by Para
+func Hashes(
+	indexConfig IndexConfig, customCachePath string, refresh time.Duration,
+	sources map[string]string, keys []string, terraformLockPath string, write bool,
+) {
+	fmt.Printf("- Cache Dir: ")
+	cacheDir, err := discoverCacheDir(customCachePath)
+	if err != nil {
+		fmt.Printf(
+			"\n* Error: Para requires a writable cache dir for operation but failed discovering one: %s\n",
+			err,
+		)
+		os.Exit(1)
+	}
+	fmt.Println(utils.PathSimplify(cacheDir))
+
+	loadingIndex, lock, err := loadIndex(indexConfig, cacheDir, refresh, true)
+	if err != nil {
+		fmt.Printf("\n* Error: %s\n", err)
+		os.Exit(1)
+	}
+	runtimeIndex := loadingIndex.BuildRuntimeIndex(indexConfig.ParallelDownloads)
+
+	explicit := len(keys) > 0
+	if !explicit {
+		keys = lock.Keys()
+	}
+	if len(keys) == 0 {
+		fmt.Println("* Error: nothing is locked yet: run Terraform via Para or use 'para lock --update <kind>.<name>'")
+		os.Exit(1)
+	}
+
+	var providers []*terraformLockProvider
+	for _, key := range keys {
+		kind, name, err := index.ParsePluginKey(key)
+		if err != nil {
+			fmt.Printf("* Error: %s\n", err)
+			os.Exit(1)
+		}
+		if kind != pluginKindProvider {
+			if explicit {
+				fmt.Printf("* Error: Terraform only locks providers but '%s' is a %s\n", key, kind)
+				os.Exit(1)
+			}
+			continue
+		}
+
+		locked := append([]*index.Plugin(nil), lock.Lookup(kind, name)...)
+		if len(locked) == 0 {
+			fmt.Printf("* Error: %s '%s' is not locked yet: use 'para lock --update %s' first\n", kind, name, key)
+			os.Exit(1)
+		}
+		// Terraform allows just 1 version per provider so we go with the newest one
+		sort.Slice(locked, func(a, b int) bool {
+			if locked[a].Version == locked[b].Version {
+				return locked[a].Platform < locked[b].Platform
+			}
+			return locked[a].SemVer.GreaterThan(locked[b].SemVer)
+		})
+		version := locked[0].Version
+
+		source, ok := sources[key]
+		if !ok {
+			source = defaultProviderSourcePrefix + name
+		}
+		provider := &terraformLockProvider{Address: source, Version: locked[0].SemVer.Core()}
+
+		fmt.Printf("- %s '%s' version '%s' as '%s':\n", kind, name, version, source)
+		for _, plugin := range locked {
+			if plugin.Version != version {
+				fmt.Printf("  * Warning: skipping version '%s' for '%s'\n", plugin.Version, plugin.Platform)
+				continue
+			}
+			h1, err := pluginHashH1(runtimeIndex, plugin)
+			if err != nil {
+				fmt.Printf("* Error: cannot hash '%s': %s\n", plugin.Url, err)
+				os.Exit(1)
+			}
+			provider.Hashes = append(provider.Hashes, h1)
+			fmt.Printf("  %s (%s)\n", h1, plugin.Platform)
+			if zh := plugin.PackageHashZH(); zh != "" {
+				provider.Hashes = append(provider.Hashes, zh)
+				fmt.Printf("  %s (%s)\n", zh, plugin.Platform)
+			}
+		}
+		providers = append(providers, provider)
+	}
+
+	if !write {
+		return
+	}
+
+	err = mergeTerraformLock(terraformLockPath, providers)
+	if err != nil {
+		fmt.Printf("* Error: cannot update '%s': %s\n", terraformLockPath, err)
+		os.Exit(1)
+	}
+	fmt.Printf("- Para merged hashes of %d provider(s) into '%s'\n", len(providers), terraformLockPath)
+}
+
+// pluginHashH1 computes the "h1:" hash over files Terraform would find in the plugin package: all files of the archive
+// named as they are in it or, for plugins distributed as bare binaries, the binary named the way Para serves it
+func pluginHashH1(runtimeIndex *index.RuntimeIndex, plugin *index.Plugin) (string, error) {
+	file := utils.DownloadableFile{Url: plugin.Url, Digest: plugin.Digest, ExtractPattern: "terraform-*"}
+	if !file.IsArchive() {
+		path, err := runtimeIndex.CachePlugin(plugin)
+		if err != nil {
+			return "", err
+		}
+		return utils.PackageHashH1(map[string]string{plugin.Filename(): path})
+	}
+
+	// the cache only keeps the extracted binary so the archive is fetched (and verified) again
+	dir, err := ioutil.TempDir("", "para.hashes.*")
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	file.ExtractPattern = ""
+	path := filepath.Join(dir, filepath.Base(plugin.Url))
+	err = file.SaveTo(path)
+	if err != nil {
+		return "", err
+	}
+	return utils.PackageHashH1Archive(path)
+}
+
+// mergeTerraformLock adds hashes to providers of the same version, sets the version and replaces hashes of providers
+// locked at other versions and appends missing providers. The file is edited with hclwrite so everything else in it
+// (constraints, comments and whatever else Para doesn't know about) is left intact.
+func mergeTerraformLock(path string, providers []*terraformLockProvider) error {
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		content, err = []byte(terraformLockHeader), nil
+	}
+	if err != nil {
+		return err
+	}
+
+	merged, err := mergeTerraformLockContent(content, path, providers)
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(path, merged, 0644)
+}
+
+func mergeTerraformLockContent(content []byte, filename string, providers []*terraformLockProvider) ([]byte, error) {
+	// hclsyntax is used to read values while hclwrite edits the file keeping its formatting and comments
+	parsed, diagnostics := hclsyntax.ParseConfig(content, filename, hcl.InitialPos)
+	if diagnostics.HasErrors() {
+		return nil, diagnostics
+	}
+	file, diagnostics := hclwrite.ParseConfig(content, filename, hcl.InitialPos)
+	if diagnostics.HasErrors() {
+		return nil, diagnostics
+	}
+
+	for _, provider := range providers {
+		labels := []string{provider.Address}
+		hashes := provider.Hashes
+		block := file.Body().FirstMatchingBlock("provider", labels)
+		if block == nil {
+			file.Body().AppendNewline()
+			block = file.Body().AppendNewBlock("provider", labels)
+		} else {
+			version, locked, err := readTerraformLockProvider(parsed.Body.(*hclsyntax.Body), provider.Address)
+			if err != nil {
+				return nil, err
+			}
+			if version == provider.Version {
+				hashes = append(locked, hashes...)
+			}
+		}
+		block.Body().SetAttributeValue("version", cty.StringVal(provider.Version))
+		block.Body().SetAttributeRaw("hashes", formatTerraformLockHashes(hashes))
+	}
+	return hclwrite.Format(file.Bytes()), nil
+}
+
+// readTerraformLockProvider reads the version and hashes of the provider locked in the file (if any)
+func readTerraformLockProvider(body *hclsyntax.Body, address string) (string, []string, error) {
+	for _, block := range body.Blocks {
+		if block.Type != "provider" || len(block.Labels) != 1 || block.Labels[0] != address {
+			continue
+		}
+		var version string
+		if attribute, ok := block.Body.Attributes["version"]; ok {
+			value, diagnostics := attribute.Expr.Value(nil)
+			if diagnostics.HasErrors() || value.IsNull() || !value.IsKnown() || value.Type() != cty.String {
+				return "", nil, fmt.Errorf("version of '%s' is not a string", address)
+			}
+			version = value.AsString()
+		}
+		var hashes []string
+		if attribute, ok := block.Body.Attributes["hashes"]; ok {
+			value, diagnostics := attribute.Expr.Value(nil)
+			if diagnostics.HasErrors() || value.IsNull() || !value.IsKnown() || !value.CanIterateElements() {
+				return "", nil, fmt.Errorf("hashes of '%s' are not a list", address)
+			}
+			for it := value.ElementIterator(); it.Next(); {
+				_, element := it.Element()
+				if element.IsNull() || !element.IsKnown() || element.Type() != cty.String {
+					return "", nil, fmt.Errorf("hashes of '%s' are not a list of strings", address)
+				}
+				hashes = append(hashes, element.AsString())
+			}
+		}
+		return version, hashes, nil
+	}
+	return "", nil, nil
+}
+
+// formatTerraformLockHashes renders unique hashes sorted one per line the same way Terraform does
+func formatTerraformLockHashes(hashes []string) hclwrite.Tokens {
+	seen := make(map[string]bool)
+	var unique []string
+	for _, hash := range hashes {
+		if !seen[hash] {
+			seen[hash] = true
+			unique = append(unique, hash)
+		}
+	}
+	sort.Strings(unique)
+
+	tokens := hclwrite.Tokens{
+		{Type: hclsyntax.TokenOBrack, Bytes: []byte("[")},
+		{Type: hclsyntax.TokenNewline, Bytes: []byte("\n")},
+	}
+	for _, hash := range unique {
+		tokens = append(tokens, hclwrite.TokensForValue(cty.StringVal(hash))...)
+		tokens = append(tokens,
+			&hclwrite.Token{Type: hclsyntax.TokenComma, Bytes: []byte(",")},
+			&hclwrite.Token{Type: hclsyntax.TokenNewline, Bytes: []byte("\n")},
+		)
+	}
+	return append(tokens, &hclwrite.Token{Type: hclsyntax.TokenCBrack, Bytes: []byte("]")})
+}
@@ -0,0 +1,148 @@
+package app
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testTerraformLock = `# This file is maintained automatically by "terraform init".
+# Manual edits may be lost in future updates.
+
+provider "registry.terraform.io/hashicorp/aws" {
+  version     = "3.0.0"
+  constraints = "~> 3.0"
+  hashes = [
+    "h1:old", # verified
+    "zh:old",
+  ]
+}
+
+# kept as is
+provider "registry.terraform.io/hashicorp/null" {
+  version = "3.1.0"
+  hashes = [
+    "h1:null",
+  ]
+  future = true
+  note   = <<-EOT
+    hashes = [ "h1:fake" ]
+  EOT
+}
+`
+
+func TestMergeTerraformLockContent(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		providers []*terraformLockProvider
+		expected  string
+	}{
+		{
+			"same version",
+			[]*terraformLockProvider{
+				{"registry.terraform.io/hashicorp/null", "3.1.0", []string{"h1:para", "h1:null"}},
+			},
+			strings.Replace(testTerraformLock, `    "h1:null",
+`, `    "h1:null",
+    "h1:para",
+`, 1),
+		},
+		{
+			"other version",
+			[]*terraformLockProvider{
+				{"registry.terraform.io/hashicorp/aws", "3.1.0", []string{"zh:new", "h1:new"}},
+			},
+			strings.Replace(testTerraformLock, `  version     = "3.0.0"
+  constraints = "~> 3.0"
+  hashes = [
+    "h1:old", # verified
+    "zh:old",
+  ]`, `  version     = "3.1.0"
+  constraints = "~> 3.0"
+  hashes = [
+    "h1:new",
+    "zh:new",
+  ]`, 1),
+		},
+		{
+			"new providers",
+			[]*terraformLockProvider{
+				{"example.com/acme/foo", "1.0.0", []string{"h1:foo"}},
+				{"example.com/acme/bar", "2.0.0", []string{"zh:bar", "h1:bar"}},
+			},
+			testTerraformLock + `
+provider "example.com/acme/foo" {
+  version = "1.0.0"
+  hashes = [
+    "h1:foo",
+  ]
+}
+
+provider "example.com/acme/bar" {
+  version = "2.0.0"
+  hashes = [
+    "h1:bar",
+    "zh:bar",
+  ]
+}
+`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			merged, err := mergeTerraformLockContent([]byte(testTerraformLock), ".terraform.lock.hcl", tc.providers)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(merged) != tc.expected {
+				t.Fatalf("expected:\n%s\ngot:\n%s", tc.expected, merged)
+			}
+		})
+	}
+}
+
+func TestMergeTerraformLockMalformed(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{"syntax", "provider \"example.com/acme/foo\" {\n", "Unclosed configuration block"},
+		{"version", "provider \"example.com/acme/foo\" {\n  version = 1\n}\n", "version of 'example.com/acme/foo'"},
+		{"hashes", "provider \"example.com/acme/foo\" {\n  hashes = \"h1:foo\"\n}\n", "hashes of 'example.com/acme/foo'"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			provider := &terraformLockProvider{"example.com/acme/foo", "1.0.0", []string{"h1:foo"}}
+			_, err := mergeTerraformLockContent([]byte(tc.content), ".terraform.lock.hcl", []*terraformLockProvider{provider})
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Fatalf("expected error containing '%s', got %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestMergeTerraformLockMissing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".terraform.lock.hcl")
+	provider := &terraformLockProvider{"registry.terraform.io/hashicorp/null", "3.1.0", []string{"h1:null"}}
+	if err := mergeTerraformLock(path, []*terraformLockProvider{provider}); err != nil {
+		t.Fatal(err)
+	}
+	if err := mergeTerraformLock(path, []*terraformLockProvider{provider}); err != nil {
+		t.Fatal(err)
+	}
+	merged, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := terraformLockHeader + `
+provider "registry.terraform.io/hashicorp/null" {
+  version = "3.1.0"
+  hashes = [
+    "h1:null",
+  ]
+}
+`
+	if string(merged) != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, merged)
+	}
+}
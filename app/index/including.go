@@ -0,0 +1,128 @@
+package index
+
+import (
+	"fmt"
+	"github.com/paraterraform/para/utils"
+	"net"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const sectionInclude = "include"
+const fieldRefresh = "refresh"
+
+// include is an entry of the include section - either just a location or a map with a location and a refresh interval
+type include struct {
+	Location string
+	Refresh  time.Duration
+}
+
+func (i *LoadingIndex) parseIncludes(location string, spec interface{}) ([]include, error) {
+	list, ok := spec.([]interface{})
+	if !ok {
+		if spec == nil {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("'%s' section of '%s' must be a list", sectionInclude, location)
+	}
+
+	var result []include
+	for _, entry := range list {
+		item := include{Refresh: i.Refresh}
+		switch value := entry.(type) {
+		case string:
+			item.Location = value
+		case map[string]interface{}:
+			item.Location, _ = value[fieldUrl].(string)
+			if refreshRaw, ok := value[fieldRefresh]; ok {
+				refresh, err := time.ParseDuration(fmt.Sprintf("%v", refreshRaw))
+				if err != nil {
+					return nil, fmt.Errorf("malformed refresh of include '%s' in '%s': %s", item.Location, location, err)
+				}
+				item.Refresh = refresh
+			}
+		}
+		if item.Location == "" {
+			return nil, fmt.Errorf("malformed include in '%s': must be a location or a map with '%s'", location, fieldUrl)
+		}
+		item.Location = utils.UrlResolve(location, item.Location)
+		if utils.UrlIsRemote(location) && !utils.UrlIsRemote(item.Location) {
+			// a remote document has no business reading local files (and telling their contents by index errors)
+			return nil, fmt.Errorf("remote '%s' cannot include local '%s'", location, item.Location)
+		}
+		result = append(result, item)
+	}
+	return result, nil
+}
+
+// loadIncludes loads included documents in the given order so that later ones override earlier ones, a document may
+// be included more than once but it cannot include itself (directly or not)
+func (i *LoadingIndex) loadIncludes(location string, spec interface{}, including []string) error {
+	includes, err := i.parseIncludes(location, spec)
+	if err != nil {
+		return err
+	}
+
+	indexCacheDir := filepath.Join(i.CacheDir, "index")
+	for _, item := range includes {
+		for _, parent := range including {
+			if includeKey(parent) == includeKey(item.Location) {
+				return fmt.Errorf(
+					"include cycle detected: %s -> %s", strings.Join(including, " -> "), item.Location,
+				)
+			}
+		}
+
+		content, _, err := utils.DownloadableFile{Url: item.Location}.ReadAllWithCache(indexCacheDir, item.Refresh)
+		if err != nil {
+			return fmt.Errorf("cannot include '%s' in '%s': %s", item.Location, location, err)
+		}
+		_, err = i.Signatures.verify(item.Location, content, indexCacheDir, item.Refresh)
+		if err != nil {
+			return err
+		}
+		err = i.loadDocument(item.Location, content, including)
+		if _, ok := err.(*SignatureError); ok {
+			return err
+		}
+		if err != nil {
+			return fmt.Errorf("cannot include '%s' in '%s': %s", item.Location, location, err)
+		}
+		i.Included = append(i.Included, item.Location)
+	}
+	return nil
+}
+
+// includeKey identifies the document regardless of how its location is spelled so that cycles cannot hide behind
+// "./", "..", "file://", "~", letter case of hosts or default ports
+func includeKey(location string) string {
+	if utils.UrlIsRemote(location) {
+		parsed, err := url.Parse(location)
+		if err != nil {
+			return location
+		}
+		scheme := strings.ToLower(parsed.Scheme)
+		port := parsed.Port()
+		if port == "" {
+			port = map[string]string{"http": "80", "https": "443"}[scheme]
+		}
+		parsed.Scheme = scheme
+		parsed.Host = net.JoinHostPort(strings.ToLower(parsed.Hostname()), port)
+		parsed.Path = path.Clean("/" + parsed.Path)
+		parsed.RawPath = ""
+		parsed.Fragment = ""
+		return parsed.String()
+	}
+	localPath, err := utils.UrlToPath(location)
+	if err != nil {
+		return location
+	}
+	absolutePath, err := filepath.Abs(localPath)
+	if err != nil {
+		return filepath.Clean(localPath)
+	}
+	return absolutePath
+}
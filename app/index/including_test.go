@@ -0,0 +1,158 @@
+package index
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+const testIncludedPlugin = `
+provider:
+  %s:
+    v1.0.0:
+      linux_amd64: {url: "https://example.com/%s", size: 1, digest: "sha256:%s"}
+`
+
+func TestLoadIncludes(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		documents map[string]string // the first one to be loaded is "root.yaml"
+		providers string            // names of providers loaded
+		err       string
+	}{
+		{"relative", map[string]string{
+			"root.yaml":       "include: [sub/a.yaml]",
+			"sub/a.yaml":      "include: [../b.yaml]\n" + testIncludedDocument("a"),
+			"b.yaml":          testIncludedDocument("b"),
+			"sub/unused.yaml": testIncludedDocument("unused"),
+		}, "a b", ""},
+		{"diamond", map[string]string{
+			"root.yaml": "include: [a.yaml, b.yaml]",
+			"a.yaml":    "include: [c.yaml]\n" + testIncludedDocument("a"),
+			"b.yaml":    "include: [c.yaml]\n" + testIncludedDocument("b"),
+			"c.yaml":    testIncludedDocument("c"),
+		}, "a b c", ""},
+		{"with refresh", map[string]string{
+			"root.yaml": "include: [{url: a.yaml, refresh: 1h}]",
+			"a.yaml":    testIncludedDocument("a"),
+		}, "a", ""},
+		{"self", map[string]string{
+			"root.yaml": "include: [root.yaml]",
+		}, "", "include cycle detected: %[1]s/root.yaml -> %[1]s/root.yaml"},
+		{"indirect", map[string]string{
+			"root.yaml": "include: [a.yaml]",
+			"a.yaml":    "include: [b.yaml]",
+			"b.yaml":    "include: [a.yaml]",
+		}, "", "include cycle detected: %[1]s/root.yaml -> %[1]s/a.yaml -> %[1]s/b.yaml -> %[1]s/a.yaml"},
+		{"file url", map[string]string{
+			"root.yaml": "include: [a.yaml]",
+			"a.yaml":    "include: ['file://{dir}/root.yaml']",
+		}, "", "include cycle detected: %[1]s/root.yaml -> %[1]s/a.yaml -> file://%[1]s/root.yaml"},
+		{"dot segments", map[string]string{
+			"root.yaml":  "include: [sub/a.yaml]",
+			"sub/a.yaml": "include: ['{dir}/sub/../root.yaml']",
+		}, "", "include cycle detected: %[1]s/root.yaml -> %[1]s/sub/a.yaml -> %[1]s/sub/../root.yaml"},
+		{"missing", map[string]string{
+			"root.yaml": "include: [missing.yaml]",
+		}, "", "cannot include '%[1]s/missing.yaml' in '%[1]s/root.yaml'"},
+		{"malformed refresh", map[string]string{
+			"root.yaml": "include: [{url: a.yaml, refresh: often}]",
+		}, "", "malformed refresh of include 'a.yaml' in '%[1]s/root.yaml'"},
+		{"not a list", map[string]string{
+			"root.yaml": "include: a.yaml",
+		}, "", "'include' section of '%[1]s/root.yaml' must be a list"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tc.documents {
+				path := filepath.Join(dir, name)
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatal(err)
+				}
+				content = strings.Replace(content, "{dir}", dir, -1)
+				if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			loadingIndex, err := DiscoverIndex([]string{filepath.Join(dir, "root.yaml")}, t.TempDir(), 0, nil)
+			if tc.err != "" {
+				expected := fmt.Sprintf(tc.err, dir)
+				if err == nil || !strings.Contains(err.Error(), expected) {
+					t.Fatalf("expected error containing '%s', got %v", expected, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var providers []string
+			for name := range loadingIndex.KindToNameToPlugins["provider"] {
+				providers = append(providers, name)
+			}
+			sort.Strings(providers)
+			if actual := strings.Join(providers, " "); actual != tc.providers {
+				t.Fatalf("expected providers '%s', got '%s'", tc.providers, actual)
+			}
+		})
+	}
+}
+
+func TestParseIncludesRemote(t *testing.T) {
+	const location = "https://example.com/index/para.idx.yaml"
+	for _, tc := range []struct {
+		include  string
+		expected string
+		err      string
+	}{
+		{"extra.yaml", "https://example.com/index/extra.yaml", ""},
+		{"https://other.example.com/para.idx.yaml", "https://other.example.com/para.idx.yaml", ""},
+		{"file:///etc/para/para.idx.yaml", "", "cannot include local 'file:///etc/para/para.idx.yaml'"},
+		{"/etc/para/para.idx.yaml", "", "cannot include local '/etc/para/para.idx.yaml'"},
+		{"~/.para/para.idx.yaml", "", "cannot include local '~/.para/para.idx.yaml'"},
+	} {
+		t.Run(tc.include, func(t *testing.T) {
+			includes, err := (&LoadingIndex{}).parseIncludes(location, []interface{}{tc.include})
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("expected error containing '%s', got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(includes) != 1 || includes[0].Location != tc.expected {
+				t.Fatalf("expected '%s', got %v", tc.expected, includes)
+			}
+		})
+	}
+}
+
+func TestIncludeKey(t *testing.T) {
+	for _, tc := range []struct {
+		a, b string
+		same bool
+	}{
+		{"https://example.com/para.idx.yaml", "https://EXAMPLE.com:443/./sub/../para.idx.yaml", true},
+		{"http://example.com/para.idx.yaml", "http://example.com:80/para.idx.yaml#top", true},
+		{"https://example.com/para.idx.yaml", "http://example.com/para.idx.yaml", false},
+		{"https://example.com/para.idx.yaml", "https://example.com:8443/para.idx.yaml", false},
+		{"/etc/para/para.idx.yaml", "file:///etc/para/../para/para.idx.yaml", true},
+		{"/etc/para/para.idx.yaml", "/etc/para/other.yaml", false},
+	} {
+		t.Run(tc.a+" "+tc.b, func(t *testing.T) {
+			if same := includeKey(tc.a) == includeKey(tc.b); same != tc.same {
+				t.Fatalf("expected the same document: %t, got %t", tc.same, same)
+			}
+		})
+	}
+}
+
+func testIncludedDocument(name string) string {
+	return fmt.Sprintf(testIncludedPlugin, name, name, name)
+}
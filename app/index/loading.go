@@ -5,8 +5,8 @@ import (
 	"github.com/paraterraform/para/utils"
 	yml "gopkg.in/ashald/yaml.v2"
 	"io/ioutil"
-	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -15,20 +15,35 @@ import (
 const fieldUrl = "url"
 const fieldSize = "size"
 const fieldDigest = "digest"
+const fieldIndexDigest = "index-digest" // optional
+
+// SectionTools is the section of the primary index (and the kind of extensions) that lists executables rather than plugins
+const SectionTools = "tools"
+const kindTool = "tool"
 
 type LoadingIndex struct {
 	CacheDir            string
 	KindToNameToPlugins map[string]map[string][]*Plugin
+	NameToTools         map[string][]*Plugin // tools share the schema with plugins but are never served to Terraform
 	Timestamp           time.Time
 	Refresh             time.Duration
 	Location            string
+	Signer              string // fingerprint of the key that signed primary index, if it was signed
+	Warnings            []string
+	Signatures          *SignaturePolicy
+	Layers              []*LoadingIndex // primary indices merged into this one (empty if just one was used)
+	Included            []string        // locations of documents included by primary index (directly or not)
 }
 
-func DiscoverIndex(candidates []string, cacheDir string, refresh time.Duration) (*LoadingIndex, error) {
+func DiscoverIndex(
+	candidates []string, cacheDir string, refresh time.Duration, signatures *SignaturePolicy,
+) (*LoadingIndex, error) {
 	var content []byte
 	var timestamp time.Time
 	var location string
+	var signer string
 	var err error
+	var skipped []string
 
 	indexCacheDir := filepath.Join(cacheDir, "index")
 
@@ -39,48 +54,106 @@ func DiscoverIndex(candidates []string, cacheDir string, refresh time.Duration)
 
 		content, timestamp, err = utils.DownloadableFile{Url: location}.ReadAllWithCache(indexCacheDir, refresh)
 		if err != nil {
+			if _, offline := err.(*utils.OfflineError); offline {
+				skipped = append(skipped, err.Error()) // unlike missing local files it's worth mentioning
+			}
 			continue
 		}
+		signer, err = signatures.verify(location, content, indexCacheDir, refresh)
+		if err != nil {
+			if signatureErr, ok := err.(*SignatureError); ok && signatureErr.Missing {
+				// unsigned index is as good as missing one
+				skipped = append(skipped, err.Error())
+				content = nil
+				continue
+			}
+			// but an invalid signature means something is off and we'd rather not fall back to anything else
+			return nil, err
+		}
 		break
 	}
 
 	if content == nil {
-		return nil, fmt.Errorf(
+		message := fmt.Sprintf(
 			"failed to discover an index file at any of given locations: %s",
-			strings.Join(candidates, ", "),
+			strings.Join(redacted(candidates), ", "),
 		)
+		if len(skipped) > 0 {
+			message += " (skipped: " + strings.Join(skipped, "; ") + ")"
+		}
+		return nil, fmt.Errorf("%s", message)
 	}
 
 	index := &LoadingIndex{
 		CacheDir:            cacheDir,
 		KindToNameToPlugins: make(map[string]map[string][]*Plugin),
+		NameToTools:         make(map[string][]*Plugin),
 		Timestamp:           timestamp,
 		Refresh:             refresh,
 		Location:            location,
+		Signer:              signer,
+		Signatures:          signatures,
 	}
 
 	return index, index.loadPrimaryIndex(content)
 }
 
+// redacted hides passwords in locations so that they can be shown to the user
+func redacted(locations []string) []string {
+	var result []string
+	for _, location := range locations {
+		result = append(result, utils.UrlRedact(location))
+	}
+	return result
+}
+
 func (i *LoadingIndex) loadPrimaryIndex(raw []byte) error {
+	return i.loadDocument(i.Location, raw, nil)
+}
+
+// loadDocument loads documents the given one includes (if any) first so that it overrides them
+func (i *LoadingIndex) loadDocument(location string, raw []byte, including []string) error {
 	var parsed map[string]interface{}
 
 	err := yml.Unmarshal(raw, &parsed)
 	if err != nil {
-		return err
+		return fmt.Errorf("cannnot decode '%s' as a valid YAML map: %s", location, err)
 	}
 
-	for kind, kindSpec := range parsed {
-		i.KindToNameToPlugins[kind] = make(map[string][]*Plugin)
+	if includeSpec, ok := parsed[sectionInclude]; ok {
+		delete(parsed, sectionInclude)
+		err = i.loadIncludes(location, includeSpec, append(including, location))
+		if err != nil {
+			return err
+		}
+	}
 
+	for kind, kindSpec := range parsed {
 		kindMap, kindSpecIsOk := kindSpec.(map[string]interface{})
+		if kind == SectionTools {
+			for name, versionsSpec := range kindMap {
+				tools, err := i.parseVersions(kindTool, name, versionsSpec, location)
+				if err != nil {
+					return err
+				}
+				i.NameToTools[name] = mergePlugins(i.NameToTools[name], tools, false)
+			}
+			continue
+		}
+
+		if _, ok := i.KindToNameToPlugins[kind]; !ok {
+			i.KindToNameToPlugins[kind] = make(map[string][]*Plugin)
+		}
 		if !kindSpecIsOk {
 			continue
 		}
 
 		for name, versionsSpec := range kindMap {
-			plugins := i.parseVersions(kind, name, versionsSpec)
-			i.KindToNameToPlugins[kind][name] = append(i.KindToNameToPlugins[kind][name], plugins...)
+			plugins, err := i.parseVersions(kind, name, versionsSpec, location)
+			if err != nil {
+				return err
+			}
+			i.KindToNameToPlugins[kind][name] = mergePlugins(i.KindToNameToPlugins[kind][name], plugins, false)
 		}
 	}
 
@@ -113,7 +186,19 @@ func (i *LoadingIndex) LoadExtension(path string) error {
 		return err
 	}
 
-	plugins := i.parseVersions(kind, name, versionsSpec)
+	if kind == SectionTools {
+		tools, err := i.parseVersions(kindTool, name, versionsSpec, path)
+		if err != nil {
+			return err
+		}
+		i.NameToTools[name] = tools
+		return nil
+	}
+
+	plugins, err := i.parseVersions(kind, name, versionsSpec, path)
+	if err != nil {
+		return err
+	}
 
 	if _, ok := i.KindToNameToPlugins[kind]; !ok {
 		i.KindToNameToPlugins[kind] = make(map[string][]*Plugin)
@@ -124,7 +209,10 @@ func (i *LoadingIndex) LoadExtension(path string) error {
 	return nil
 }
 
-func (i *LoadingIndex) parseVersions(kind, name string, versions interface{}) (result []*Plugin) {
+// parseVersions skips anything that doesn't look right but fails if referenced URL doesn't pass signature verification
+func (i *LoadingIndex) parseVersions(
+	kind, name string, versions interface{}, source string,
+) (result []*Plugin, err error) {
 	var versionMap map[string]interface{}
 
 	extensionsCacheDir := filepath.Join(i.CacheDir, "index")
@@ -135,17 +223,31 @@ func (i *LoadingIndex) parseVersions(kind, name string, versions interface{}) (r
 		if !versionIndexUrlOk {
 			return
 		}
-		versionSpecBytes, _, err := utils.DownloadableFile{Url: versionIndexUrl}.ReadAllWithCache(extensionsCacheDir, i.Refresh)
-		if err != nil {
+		versionSpecBytes, _, errRead := utils.DownloadableFile{Url: versionIndexUrl}.ReadAllWithCache(
+			extensionsCacheDir, i.Refresh,
+		)
+		if errRead != nil {
 			return
 		}
-		err = yml.Unmarshal(versionSpecBytes, &versionMap)
+		_, err = i.Signatures.verify(versionIndexUrl, versionSpecBytes, extensionsCacheDir, i.Refresh)
 		if err != nil {
 			return
 		}
+		if yml.Unmarshal(versionSpecBytes, &versionMap) != nil {
+			return
+		}
 	}
 
 	for version, platformsSpec := range versionMap {
+		semVer, err := ParseVersion(version)
+		if err == nil && !strings.HasPrefix(version, "v") {
+			err = fmt.Errorf("malformed version '%s': must start with 'v'", version)
+		}
+		if err != nil {
+			i.Warnings = append(i.Warnings, fmt.Sprintf("ignoring a version of %s '%s': %s", kind, name, err))
+			continue
+		}
+
 		platformsMap, platformsSpecIsOk := platformsSpec.(map[string]interface{})
 		if !platformsSpecIsOk {
 			continue
@@ -175,14 +277,19 @@ func (i *LoadingIndex) parseVersions(kind, name string, versions interface{}) (r
 				continue // TODO trace
 			}
 
+			indexDigestStr, _ := specMap[fieldIndexDigest].(string)
+
 			p := Plugin{
-				Kind:     kind,
-				Name:     name,
-				Platform: platform,
-				Version:  version,
-				Size:     size,
-				Digest:   digestStr,
-				Url:      urlStr,
+				Kind:        kind,
+				Name:        name,
+				Platform:    platform,
+				Version:     version,
+				SemVer:      semVer,
+				Size:        size,
+				Digest:      digestStr,
+				Url:         urlStr,
+				Source:      source,
+				IndexDigest: indexDigestStr,
 			}
 
 			result = append(result, &p)
@@ -191,7 +298,65 @@ func (i *LoadingIndex) parseVersions(kind, name string, versions interface{}) (r
 	return
 }
 
-func (i *LoadingIndex) BuildRuntimeIndex() *RuntimeIndex {
+// ListVersions returns all distinct versions known for the given plugin sorted from the oldest to the newest
+func (i *LoadingIndex) ListVersions(kind, name string) Versions {
+	return listVersions(i.KindToNameToPlugins[kind][name])
+}
+
+// ListToolVersions returns all distinct versions of the given tool available for the given platform sorted from the
+// oldest to the newest
+func (i *LoadingIndex) ListToolVersions(name, platform string) Versions {
+	var tools []*Plugin
+	for _, t := range i.NameToTools[name] {
+		if t.Platform == platform {
+			tools = append(tools, t)
+		}
+	}
+	return listVersions(tools)
+}
+
+// FindTool returns the given version of the tool for the given platform or nil if the index doesn't list it
+func (i *LoadingIndex) FindTool(name string, version *Version, platform string) *Plugin {
+	for _, t := range i.NameToTools[name] {
+		if t.SemVer.Equal(version) && t.Platform == platform {
+			return t
+		}
+	}
+	return nil
+}
+
+func listVersions(plugins []*Plugin) Versions {
+	var result Versions
+	seen := make(map[string]bool)
+	for _, p := range plugins {
+		if seen[p.Version] {
+			continue
+		}
+		seen[p.Version] = true
+		result = append(result, p.SemVer)
+	}
+	sort.Sort(result)
+	return result
+}
+
+// ResolveVersion returns the newest version of the given plugin that satisfies the constraints
+func (i *LoadingIndex) ResolveVersion(kind, name string, constraints Constraints) (*Version, error) {
+	versions := i.ListVersions(kind, name)
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("%s '%s' is not present in the index", kind, name)
+	}
+	resolved := constraints.Latest(versions)
+	if resolved == nil {
+		return nil, fmt.Errorf(
+			"none of the known versions of %s '%s' satisfies '%s'", kind, name, constraints,
+		)
+	}
+	return resolved, nil
+}
+
+// BuildRuntimeIndex prepares the index to serve plugins downloading at most given number of them at the same time (0
+// means no limit)
+func (i *LoadingIndex) BuildRuntimeIndex(parallelDownloads int) *RuntimeIndex {
 	platformToPlugins := make(map[string]map[string]*Plugin)
 
 	for _, nameToPlugins := range i.KindToNameToPlugins {
@@ -205,10 +370,17 @@ func (i *LoadingIndex) BuildRuntimeIndex() *RuntimeIndex {
 		}
 	}
 
+	var slots chan struct{}
+	if parallelDownloads > 0 {
+		slots = make(chan struct{}, parallelDownloads)
+	}
+
 	return &RuntimeIndex{
 		platformToFilenameToPlugin: platformToPlugins,
 		cacheDir:                   i.CacheDir,
-		openFiles:                  make(map[string]*os.File),
+		downloads:                  make(map[string]*pluginDownload),
+		downloadSlots:              slots,
 		alreadyOpened:              make(map[string]int),
+		served:                     make(map[string]*Plugin),
 	}
 }
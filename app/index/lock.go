@@ -0,0 +1,240 @@
+package index
+
+import (
+	"fmt"
+	yml "gopkg.in/ashald/yaml.v2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const lockHeader = `# This file is maintained by Para - manual edits may be lost.
+# It records plugin versions and digests resolved for this project - consider checking it into version control.
+# Run 'para lock --update' to re-resolve locked plugins.
+`
+
+type lockedPlugin struct {
+	Url    string `yaml:"url"`
+	Size   uint64 `yaml:"size"`
+	Digest string `yaml:"digest"`
+}
+
+// Lock records exact plugin versions and digests used by a project, it's stored in the same format as primary index
+type Lock struct {
+	Path string
+
+	kindToNameToPlugins map[string]map[string][]*Plugin
+}
+
+func NewLock(path string) *Lock {
+	return &Lock{Path: path, kindToNameToPlugins: make(map[string]map[string][]*Plugin)}
+}
+
+// LoadLock reads a lock file at the given path, a missing file is treated as an empty lock
+func LoadLock(path string) (*Lock, error) {
+	lock := NewLock(path)
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return lock, nil
+		}
+		return nil, err
+	}
+
+	var parsed map[string]map[string]map[string]map[string]lockedPlugin
+	err = yml.UnmarshalStrict(content, &parsed)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode lock file '%s': %s", path, err)
+	}
+
+	for kind, nameToVersions := range parsed {
+		for name, versionToPlatforms := range nameToVersions {
+			for version, platformToSpec := range versionToPlatforms {
+				semVer, err := ParseVersion(version)
+				if err != nil {
+					return nil, fmt.Errorf("cannot decode lock file '%s': %s '%s': %s", path, kind, name, err)
+				}
+				for platform, spec := range platformToSpec {
+					lock.Record(&Plugin{
+						Kind:     kind,
+						Name:     name,
+						Platform: platform,
+						Version:  version,
+						SemVer:   semVer,
+						Size:     spec.Size,
+						Digest:   spec.Digest,
+						Url:      spec.Url,
+					})
+				}
+			}
+		}
+	}
+
+	return lock, nil
+}
+
+// Save writes the lock file atomically (via a temp file in the same dir)
+func (l *Lock) Save() error {
+	serialized := make(map[string]map[string]map[string]map[string]lockedPlugin)
+	for kind, nameToPlugins := range l.kindToNameToPlugins {
+		for name, plugins := range nameToPlugins {
+			for _, p := range plugins {
+				if _, ok := serialized[kind]; !ok {
+					serialized[kind] = make(map[string]map[string]map[string]lockedPlugin)
+				}
+				if _, ok := serialized[kind][name]; !ok {
+					serialized[kind][name] = make(map[string]map[string]lockedPlugin)
+				}
+				if _, ok := serialized[kind][name][p.Version]; !ok {
+					serialized[kind][name][p.Version] = make(map[string]lockedPlugin)
+				}
+				serialized[kind][name][p.Version][p.Platform] = lockedPlugin{
+					Url: p.Url, Size: p.Size, Digest: p.LockedDigest(),
+				}
+			}
+		}
+	}
+
+	content, err := yml.Marshal(serialized)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(l.Path)
+	temp, err := ioutil.TempFile(dir, fmt.Sprintf(".%s.*", filepath.Base(l.Path)))
+	if err != nil {
+		return err
+	}
+	_, err = temp.WriteString(lockHeader + string(content))
+	if errClose := temp.Close(); err == nil {
+		err = errClose
+	}
+	if err == nil {
+		err = os.Chmod(temp.Name(), 0644)
+	}
+	if err != nil {
+		_ = os.Remove(temp.Name())
+		return err
+	}
+	return os.Rename(temp.Name(), l.Path)
+}
+
+func (l *Lock) IsEmpty() bool {
+	return len(l.kindToNameToPlugins) == 0
+}
+
+// Keys returns "<kind>.<name>" for every locked plugin sorted alphabetically
+func (l *Lock) Keys() []string {
+	var result []string
+	for kind, nameToPlugins := range l.kindToNameToPlugins {
+		for name := range nameToPlugins {
+			result = append(result, kind+"."+name)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// Lookup returns all locked entries (for all versions and platforms) of the given plugin
+func (l *Lock) Lookup(kind, name string) []*Plugin {
+	return l.kindToNameToPlugins[kind][name]
+}
+
+// Record adds the plugin to the lock replacing an entry for the same version and platform, reports if lock changed
+func (l *Lock) Record(plugin *Plugin) bool {
+	if _, ok := l.kindToNameToPlugins[plugin.Kind]; !ok {
+		l.kindToNameToPlugins[plugin.Kind] = make(map[string][]*Plugin)
+	}
+	plugins := l.kindToNameToPlugins[plugin.Kind][plugin.Name]
+	for idx, existing := range plugins {
+		if existing.Version == plugin.Version && existing.Platform == plugin.Platform {
+			if existing.Url == plugin.Url && existing.Size == plugin.Size && existing.LockedDigest() == plugin.LockedDigest() {
+				return false
+			}
+			plugins[idx] = plugin
+			return true
+		}
+	}
+	l.kindToNameToPlugins[plugin.Kind][plugin.Name] = append(plugins, plugin)
+	return true
+}
+
+// Replace drops all locked entries of the given plugin and records given ones instead
+func (l *Lock) Replace(kind, name string, plugins []*Plugin) {
+	if _, ok := l.kindToNameToPlugins[kind]; !ok {
+		l.kindToNameToPlugins[kind] = make(map[string][]*Plugin)
+	}
+	delete(l.kindToNameToPlugins[kind], name)
+	for _, p := range plugins {
+		l.Record(p)
+	}
+}
+
+// ApplyLock drops all versions and platforms of locked plugins that are not in the lock (so that nothing is served
+// without a locked digest) and fails if the index provides a different digest for any of locked versions or if a locked
+// version is available for the given platform but was never locked for it. Plugins that are not locked are left intact.
+func (i *LoadingIndex) ApplyLock(lock *Lock, platform string) error {
+	var conflicts []string
+
+	for kind, nameToPlugins := range lock.kindToNameToPlugins {
+		for name, lockedPlugins := range nameToPlugins {
+			lockedVersions := make(map[string]bool)
+			lockedDigests := make(map[string]string)
+			for _, p := range lockedPlugins {
+				lockedVersions[p.Version] = true
+				lockedDigests[p.Version+"/"+p.Platform] = p.LockedDigest()
+			}
+
+			var plugins []*Plugin
+			var unlocked []string
+			conflicting := false
+			for _, p := range i.KindToNameToPlugins[kind][name] {
+				if !lockedVersions[p.Version] {
+					continue
+				}
+				lockedDigest, ok := lockedDigests[p.Version+"/"+p.Platform]
+				if !ok {
+					if p.Platform == platform {
+						unlocked = append(unlocked, p.Version)
+					}
+					continue
+				}
+				if lockedDigest != p.LockedDigest() {
+					conflicts = append(conflicts, fmt.Sprintf(
+						"%s '%s' version '%s' for '%s' is locked with digest '%s' but index provides '%s'",
+						kind, name, p.Version, p.Platform, lockedDigest, p.LockedDigest(),
+					))
+					conflicting = true
+					continue
+				}
+				plugins = append(plugins, p)
+			}
+			if len(unlocked) > 0 {
+				conflicts = append(conflicts, fmt.Sprintf(
+					"%s '%s' version '%s' is not locked for '%s' (run 'para lock --update %s.%s' to lock it)",
+					kind, name, strings.Join(unlocked, "', '"), platform, kind, name,
+				))
+				continue
+			}
+			if len(plugins) == 0 && !conflicting {
+				conflicts = append(conflicts, fmt.Sprintf(
+					"none of locked versions of %s '%s' is available in the index (or satisfies pins)", kind, name,
+				))
+				continue
+			}
+			i.KindToNameToPlugins[kind][name] = plugins
+		}
+	}
+
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		return fmt.Errorf(
+			"index does not match lock file '%s' (run 'para lock --update' to re-resolve):\n  - %s",
+			lock.Path, strings.Join(conflicts, "\n  - "),
+		)
+	}
+	return nil
+}
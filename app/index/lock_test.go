@@ -0,0 +1,134 @@
+package index
+
+import (
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func testLockedPlugin(name, version, platform, digest string) *Plugin {
+	return &Plugin{
+		Kind:     "provider",
+		Name:     name,
+		Platform: platform,
+		Version:  version,
+		SemVer:   MustParseVersion(version),
+		Size:     1,
+		Digest:   digest,
+		Url:      "https://example.com/" + name + "/" + version + "/" + platform,
+	}
+}
+
+func TestLockSaveAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "para.lock.yaml")
+
+	missing, err := LoadLock(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !missing.IsEmpty() {
+		t.Fatal("a missing lock file must be treated as an empty lock")
+	}
+
+	lock := NewLock(path)
+	foo := testLockedPlugin("foo", "v1.1.0", "linux_amd64", "sha256:f110l")
+	if !lock.Record(foo) {
+		t.Fatal("recording a new plugin must change the lock")
+	}
+	if lock.Record(testLockedPlugin("foo", "v1.1.0", "linux_amd64", "sha256:f110l")) {
+		t.Fatal("recording the same plugin again must not change the lock")
+	}
+	if !lock.Record(testLockedPlugin("foo", "v1.1.0", "linux_amd64", "sha256:other")) {
+		t.Fatal("recording a different digest must change the lock")
+	}
+	lock.Record(foo)
+	lock.Record(testLockedPlugin("bar", "v0.1.0", "linux_amd64", "sha256:b010l"))
+	if err := lock.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadLock(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := loaded.Keys(); !reflect.DeepEqual(keys, []string{"provider.bar", "provider.foo"}) {
+		t.Fatalf("unexpected keys %v", keys)
+	}
+	locked := loaded.Lookup("provider", "foo")
+	if len(locked) != 1 || !reflect.DeepEqual(*locked[0], *foo) {
+		t.Fatalf("expected %+v to survive the round trip, got %+v", *foo, locked)
+	}
+
+	loaded.Replace("provider", "foo", []*Plugin{testLockedPlugin("foo", "v2.0.0", "linux_amd64", "sha256:f200l")})
+	if locked := loaded.Lookup("provider", "foo"); len(locked) != 1 || locked[0].Version != "v2.0.0" {
+		t.Fatalf("expected only the replacement to be locked, got %+v", locked)
+	}
+}
+
+func TestApplyLock(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		platform string
+		locked   []*Plugin
+		foo      string // versions and platforms left exposed
+		err      string
+	}{
+		{"locked", "linux_amd64", []*Plugin{
+			testLockedPlugin("foo", "v1.1.0", "linux_amd64", "sha256:f110l"),
+		}, "v1.1.0/linux_amd64", ""},
+		{"several versions", "linux_amd64", []*Plugin{
+			testLockedPlugin("foo", "v1.0.0", "linux_amd64", "sha256:f100l"),
+			testLockedPlugin("foo", "v2.0.0", "linux_amd64", "sha256:f200l"),
+		}, "v1.0.0/linux_amd64 v2.0.0/linux_amd64", ""},
+		{"several platforms", "darwin_amd64", []*Plugin{
+			testLockedPlugin("foo", "v1.0.0", "linux_amd64", "sha256:f100l"),
+			testLockedPlugin("foo", "v1.0.0", "darwin_amd64", "sha256:f100d"),
+		}, "v1.0.0/darwin_amd64 v1.0.0/linux_amd64", ""},
+		{"other platform is not served", "linux_amd64", []*Plugin{
+			testLockedPlugin("foo", "v1.0.0", "linux_amd64", "sha256:f100l"),
+		}, "v1.0.0/linux_amd64", ""},
+		{"current platform is not locked", "darwin_amd64", []*Plugin{
+			testLockedPlugin("foo", "v1.0.0", "linux_amd64", "sha256:f100l"),
+		}, "", "provider 'foo' version 'v1.0.0' is not locked for 'darwin_amd64' (run 'para lock --update provider.foo'"},
+		{"not available for current platform", "windows_amd64", []*Plugin{
+			testLockedPlugin("foo", "v1.0.0", "linux_amd64", "sha256:f100l"),
+		}, "v1.0.0/linux_amd64", ""},
+		{"not locked", "linux_amd64", []*Plugin{
+			testLockedPlugin("bar", "v0.1.0", "linux_amd64", "sha256:b010l"),
+		}, "v1.0.0/darwin_amd64 v1.0.0/linux_amd64 v1.1.0/linux_amd64 v2.0.0/linux_amd64", ""},
+		{"digest mismatch", "linux_amd64", []*Plugin{
+			testLockedPlugin("foo", "v1.1.0", "linux_amd64", "sha256:tampered"),
+		}, "", "is locked with digest 'sha256:tampered' but index provides 'sha256:f110l'"},
+		{"version gone", "linux_amd64", []*Plugin{
+			testLockedPlugin("foo", "v0.9.0", "linux_amd64", "sha256:f090l"),
+		}, "", "none of locked versions of provider 'foo' is available"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			loadingIndex := loadTestIndex(t, testIndex)
+			lock := NewLock("para.lock.yaml")
+			for _, p := range tc.locked {
+				lock.Record(p)
+			}
+			err := loadingIndex.ApplyLock(lock, tc.platform)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("expected error containing '%s', got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var exposed []string
+			for _, p := range loadingIndex.KindToNameToPlugins["provider"]["foo"] {
+				exposed = append(exposed, p.Version+"/"+p.Platform)
+			}
+			sort.Strings(exposed)
+			if actual := strings.Join(exposed, " "); actual != tc.foo {
+				t.Fatalf("expected '%s' to be exposed, got '%s'", tc.foo, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package index
+
+import (
+	"fmt"
+	"github.com/paraterraform/para/utils"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Merge modes tell what a primary index overrides when it's merged over the previous ones
+const (
+	MergeVersions = "versions" // only versions it lists, other versions of the same plugin are kept
+	MergePlugins  = "plugins"  // whole plugins it lists
+)
+
+// MergeIndices loads all given primary indices and merges them in the given order so that later ones take precedence.
+// Every index must be loaded: failing to load any of them (or any signature problem) is fatal as serving plugins from
+// the rest would silently drop whatever that index overrides.
+func MergeIndices(
+	locations []string, mode string, cacheDir string, refresh time.Duration, signatures *SignaturePolicy,
+) (*LoadingIndex, error) {
+	if mode != MergeVersions && mode != MergePlugins {
+		return nil, fmt.Errorf(
+			"unknown merge mode '%s': must be either '%s' or '%s'", mode, MergeVersions, MergePlugins,
+		)
+	}
+
+	indexCacheDir := filepath.Join(cacheDir, "index")
+
+	merged := &LoadingIndex{
+		CacheDir:            cacheDir,
+		KindToNameToPlugins: make(map[string]map[string][]*Plugin),
+		NameToTools:         make(map[string][]*Plugin),
+		Timestamp:           time.Now(),
+		Refresh:             refresh,
+		Signatures:          signatures,
+	}
+
+	for _, location := range locations {
+		content, timestamp, err := utils.DownloadableFile{Url: location}.ReadAllWithCache(indexCacheDir, refresh)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load primary index '%s': %s", location, err)
+		}
+		signer, err := signatures.verify(location, content, indexCacheDir, refresh)
+		if err != nil {
+			return nil, err
+		}
+
+		layer := &LoadingIndex{
+			CacheDir:            cacheDir,
+			KindToNameToPlugins: make(map[string]map[string][]*Plugin),
+			NameToTools:         make(map[string][]*Plugin),
+			Timestamp:           timestamp,
+			Refresh:             refresh,
+			Location:            location,
+			Signer:              signer,
+			Signatures:          signatures,
+		}
+		err = layer.loadPrimaryIndex(content)
+		if err != nil {
+			return nil, err
+		}
+
+		merged.merge(layer, mode == MergePlugins)
+		merged.Layers = append(merged.Layers, layer)
+		merged.Warnings = append(merged.Warnings, layer.Warnings...)
+		if timestamp.Before(merged.Timestamp) {
+			merged.Timestamp = timestamp // as old as the oldest of them
+		}
+	}
+	merged.Location = strings.Join(locations, ", ")
+
+	return merged, nil
+}
+
+func (i *LoadingIndex) merge(layer *LoadingIndex, wholePlugins bool) {
+	for kind, nameToPlugins := range layer.KindToNameToPlugins {
+		if _, ok := i.KindToNameToPlugins[kind]; !ok {
+			i.KindToNameToPlugins[kind] = make(map[string][]*Plugin)
+		}
+		for name, plugins := range nameToPlugins {
+			i.KindToNameToPlugins[kind][name] = mergePlugins(i.KindToNameToPlugins[kind][name], plugins, wholePlugins)
+		}
+	}
+	for name, tools := range layer.NameToTools {
+		i.NameToTools[name] = mergePlugins(i.NameToTools[name], tools, wholePlugins)
+	}
+}
+
+// mergePlugins overrides all versions (or just the same versions) of a plugin with ones from another index
+func mergePlugins(base, override []*Plugin, wholePlugins bool) []*Plugin {
+	if wholePlugins {
+		return override
+	}
+	overridden := make(map[string]bool)
+	for _, p := range override {
+		overridden[p.SemVer.Core()] = true
+	}
+	result := append([]*Plugin(nil), override...)
+	for _, p := range base {
+		if !overridden[p.SemVer.Core()] {
+			result = append(result, p)
+		}
+	}
+	return result
+}
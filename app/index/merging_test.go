@@ -0,0 +1,105 @@
+package index
+
+import (
+	"github.com/paraterraform/para/utils"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testOverridingIndex = `
+provider:
+  foo:
+    v1.1.0:
+      linux_amd64: {url: "https://example.com/override/foo/1.1.0/linux", size: 2, digest: "sha256:o110l"}
+    v3.0.0:
+      linux_amd64: {url: "https://example.com/override/foo/3.0.0/linux", size: 2, digest: "sha256:o300l"}
+`
+
+func TestMergeIndices(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, "base.idx.yaml")
+	override := filepath.Join(dir, "override.idx.yaml")
+	malformed := filepath.Join(dir, "malformed.idx.yaml")
+	for path, content := range map[string]string{base: testIndex, override: testOverridingIndex, malformed: "[oops"} {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tc := range []struct {
+		name      string
+		locations []string
+		mode      string
+		foo       string // versions exposed
+		bar       string
+		err       string
+	}{
+		{"versions", []string{base, override}, MergeVersions, "v1.0.0 v1.1.0 v2.0.0 v3.0.0", "v0.1.0", ""},
+		{"plugins", []string{base, override}, MergePlugins, "v1.1.0 v3.0.0", "v0.1.0", ""},
+		{"reversed", []string{override, base}, MergePlugins, "v1.0.0 v1.1.0 v2.0.0", "v0.1.0", ""},
+		{"missing layer", []string{base, filepath.Join(dir, "missing.idx.yaml")}, MergeVersions, "", "", "cannot load"},
+		{"malformed layer", []string{base, malformed}, MergeVersions, "", "", "malformed.idx.yaml"},
+		{"unknown mode", []string{base}, "whatever", "", "", "unknown merge mode"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			merged, err := MergeIndices(tc.locations, tc.mode, t.TempDir(), 0, nil)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("expected error containing '%s', got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual := listTestVersions(merged, "provider", "foo"); actual != tc.foo {
+				t.Fatalf("expected foo '%s', got '%s'", tc.foo, actual)
+			}
+			if actual := listTestVersions(merged, "provider", "bar"); actual != tc.bar {
+				t.Fatalf("expected bar '%s', got '%s'", tc.bar, actual)
+			}
+			if len(merged.Layers) != len(tc.locations) {
+				t.Fatalf("expected %d layers, got %d", len(tc.locations), len(merged.Layers))
+			}
+		})
+	}
+
+	t.Run("override wins", func(t *testing.T) {
+		merged, err := MergeIndices([]string{base, override}, MergeVersions, t.TempDir(), 0, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range merged.KindToNameToPlugins["provider"]["foo"] {
+			if p.Version == "v1.1.0" && p.Digest != "sha256:o110l" {
+				t.Fatalf("expected v1.1.0 to come from the later index, got %s", p.Digest)
+			}
+		}
+	})
+}
+
+func TestMergeIndicesUnsigned(t *testing.T) {
+	key, err := ioutil.ReadFile(filepath.Join("..", "..", "utils", "testdata", "openpgp", "alice.asc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyRing := &utils.PgpKeyRing{}
+	if err := keyRing.Add(key); err != nil {
+		t.Fatal(err)
+	}
+	base := filepath.Join(t.TempDir(), "base.idx.yaml")
+	if err := ioutil.WriteFile(base, []byte(testIndex), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = MergeIndices([]string{base}, MergeVersions, t.TempDir(), 0, &SignaturePolicy{KeyRing: keyRing})
+	if err != nil {
+		t.Fatalf("unsigned indices should be accepted unless signatures are required: %s", err)
+	}
+	policy := &SignaturePolicy{KeyRing: keyRing, Required: true}
+	_, err = MergeIndices([]string{base}, MergeVersions, t.TempDir(), 0, policy)
+	if signatureErr, ok := err.(*SignatureError); !ok || !signatureErr.Missing {
+		t.Fatalf("expected missing signature to be fatal, got %v", err)
+	}
+}
@@ -0,0 +1,86 @@
+package index
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Pin restricts versions of a single plugin that would be exposed to Terraform
+type Pin struct {
+	Kind        string
+	Name        string
+	Constraints Constraints
+}
+
+func (p Pin) Key() string {
+	return p.Kind + "." + p.Name
+}
+
+// ParsePluginKey splits a key in the format of <kind>.<name> into its parts
+func ParsePluginKey(key string) (kind, name string, err error) {
+	tokens := strings.Split(strings.ToLower(key), ".")
+	if len(tokens) != 2 || tokens[0] == "" || tokens[1] == "" {
+		return "", "", fmt.Errorf("plugin '%s' does not match expected pattern of <kind>.<name>", key)
+	}
+	return tokens[0], tokens[1], nil
+}
+
+// ParsePins parses a map in the format of "<kind>.<name>: <constraint>" into a list of pins sorted by their keys
+func ParsePins(raw map[string]string) ([]Pin, error) {
+	var result []Pin
+	for key, constraint := range raw {
+		kind, name, err := ParsePluginKey(key)
+		if err != nil {
+			return nil, err
+		}
+		constraints, err := ParseConstraints(constraint)
+		if err != nil {
+			return nil, fmt.Errorf("plugin pin '%s': %s", key, err)
+		}
+		result = append(result, Pin{
+			Kind:        kind,
+			Name:        name,
+			Constraints: constraints,
+		})
+	}
+	sort.Slice(result, func(a, b int) bool { return result[a].Key() < result[b].Key() })
+	return result, nil
+}
+
+// ApplyPins drops all versions of pinned plugins that do not satisfy their constraints (or all but the newest one
+// satisfying them if latestOnly is set). Plugins that are not pinned are left intact. It returns versions left exposed
+// for every pin in the same order as pins were given.
+func (i *LoadingIndex) ApplyPins(pins []Pin, latestOnly bool) ([]Versions, error) {
+	var result []Versions
+	for _, pin := range pins {
+		versions := i.ListVersions(pin.Kind, pin.Name)
+		if len(versions) == 0 {
+			return nil, fmt.Errorf("pinned %s '%s' is not present in the index", pin.Kind, pin.Name)
+		}
+		matching := pin.Constraints.Filter(versions)
+		if len(matching) == 0 {
+			return nil, fmt.Errorf(
+				"none of the known versions of %s '%s' satisfies '%s'", pin.Kind, pin.Name, pin.Constraints,
+			)
+		}
+		if latestOnly {
+			matching = matching[len(matching)-1:]
+		}
+
+		allowed := make(map[string]bool)
+		for _, v := range matching {
+			allowed[v.String()] = true
+		}
+		var plugins []*Plugin
+		for _, p := range i.KindToNameToPlugins[pin.Kind][pin.Name] {
+			if allowed[p.Version] {
+				plugins = append(plugins, p)
+			}
+		}
+		i.KindToNameToPlugins[pin.Kind][pin.Name] = plugins
+
+		result = append(result, matching)
+	}
+	return result, nil
+}
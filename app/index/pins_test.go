@@ -0,0 +1,100 @@
+package index
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testIndex = `
+provider:
+  foo:
+    v1.0.0:
+      linux_amd64: {url: "https://example.com/foo/1.0.0/linux", size: 1, digest: "sha256:f100l"}
+      darwin_amd64: {url: "https://example.com/foo/1.0.0/darwin", size: 1, digest: "sha256:f100d"}
+    v1.1.0:
+      linux_amd64: {url: "https://example.com/foo/1.1.0/linux", size: 1, digest: "sha256:f110l"}
+    v2.0.0:
+      linux_amd64: {url: "https://example.com/foo/2.0.0/linux", size: 1, digest: "sha256:f200l"}
+  bar:
+    v0.1.0:
+      linux_amd64: {url: "https://example.com/bar/0.1.0/linux", size: 1, digest: "sha256:b010l"}
+`
+
+// loadTestIndex writes the document to a temp dir and loads it as the primary index
+func loadTestIndex(t *testing.T, document string) *LoadingIndex {
+	path := filepath.Join(t.TempDir(), "para.idx.yaml")
+	if err := ioutil.WriteFile(path, []byte(document), 0644); err != nil {
+		t.Fatal(err)
+	}
+	loadingIndex, err := DiscoverIndex([]string{path}, t.TempDir(), 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loadingIndex
+}
+
+func listTestVersions(loadingIndex *LoadingIndex, kind, name string) string {
+	var result []string
+	for _, v := range loadingIndex.ListVersions(kind, name) {
+		result = append(result, v.String())
+	}
+	return strings.Join(result, " ")
+}
+
+func TestApplyPins(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		pins       map[string]string
+		latestOnly bool
+		foo        string // versions left exposed
+		err        string
+	}{
+		{"exact", map[string]string{"provider.foo": "1.1.0"}, false, "v1.1.0", ""},
+		{"pessimistic", map[string]string{"provider.foo": "~> 1.0"}, false, "v1.0.0 v1.1.0", ""},
+		{"latest only", map[string]string{"provider.foo": "~> 1.0"}, true, "v1.1.0", ""},
+		{"excluded", map[string]string{"provider.foo": ">= 1.0, != 1.1.0"}, false, "v1.0.0 v2.0.0", ""},
+		{"case insensitive key", map[string]string{"Provider.Foo": "2.0.0"}, false, "v2.0.0", ""},
+		{"not pinned", map[string]string{"provider.bar": "0.1.0"}, false, "v1.0.0 v1.1.0 v2.0.0", ""},
+		{"nothing satisfies", map[string]string{"provider.foo": "> 3.0"}, false, "", "none of the known versions"},
+		{"not in index", map[string]string{"provider.baz": "1.0.0"}, false, "", "is not present in the index"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			loadingIndex := loadTestIndex(t, testIndex)
+			pins, err := ParsePins(tc.pins)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = loadingIndex.ApplyPins(pins, tc.latestOnly)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("expected error containing '%s', got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual := listTestVersions(loadingIndex, "provider", "foo"); actual != tc.foo {
+				t.Fatalf("expected '%s' to be exposed, got '%s'", tc.foo, actual)
+			}
+			if actual := listTestVersions(loadingIndex, "provider", "bar"); actual != "v0.1.0" {
+				t.Fatalf("bar should be left intact, got '%s'", actual)
+			}
+		})
+	}
+}
+
+func TestParsePinsMalformed(t *testing.T) {
+	for _, pins := range []map[string]string{
+		{"foo": "1.0.0"},
+		{"provider.foo.bar": "1.0.0"},
+		{".foo": "1.0.0"},
+		{"provider.foo": "latest"},
+	} {
+		if _, err := ParsePins(pins); err == nil {
+			t.Errorf("expected %v to be rejected", pins)
+		}
+	}
+}
@@ -2,6 +2,8 @@ package index
 
 import (
 	"fmt"
+	"github.com/paraterraform/para/utils"
+	"strings"
 )
 
 type Plugin struct {
@@ -9,11 +11,34 @@ type Plugin struct {
 	Name     string
 	Kind     string
 	Version  string
+	SemVer   *Version
 	Size     uint64
 	Digest   string
 	Url      string
+	Source   string // location of the index (or path to the extension) that listed the plugin
+	// the digest from the index the plugin was exported from (for indices generated by 'para bundle import') - the file
+	// is verified with Digest but lock files record this one so that they keep matching the original index
+	IndexDigest string
+}
+
+// LockedDigest returns the digest lock files record for the plugin
+func (p Plugin) LockedDigest() string {
+	if p.IndexDigest != "" {
+		return p.IndexDigest
+	}
+	return p.Digest
 }
 
 func (p Plugin) Filename() string {
 	return fmt.Sprintf("terraform-%s-%s_%s", p.Kind, p.Name, p.Version)
 }
+
+// PackageHashZH returns Terraform's legacy "zh:" hash if it can be derived from the index alone (that's only possible
+// when plugin is distributed as a zip archive with a sha256 digest) or an empty string otherwise
+func (p Plugin) PackageHashZH() string {
+	const sha256Prefix = "sha256:"
+	if strings.HasSuffix(strings.ToLower(p.Url), ".zip") && strings.HasPrefix(p.Digest, sha256Prefix) {
+		return utils.PackageHashPrefixZH + p.Digest[len(sha256Prefix):]
+	}
+	return ""
+}
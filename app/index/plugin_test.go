@@ -0,0 +1,26 @@
+package index
+
+import "testing"
+
+func TestPluginPackageHashZH(t *testing.T) {
+	const digest = "6f3c3f3c2b83e3b0d3b0b4a43b1e09d1c6b4ff3c1fa1d0c3a8c6e2e3f1e0c2a1"
+	for _, tc := range []struct {
+		url    string
+		digest string
+		zh     string
+	}{
+		{"https://example.com/terraform-provider-foo_1.0.0_linux_amd64.zip", "sha256:" + digest, "zh:" + digest},
+		{"https://example.com/terraform-provider-foo_1.0.0_linux_amd64.ZIP", "sha256:" + digest, "zh:" + digest},
+		{"https://example.com/terraform-provider-foo_1.0.0_linux_amd64.zip", "md5:d41d8cd98f00b204e9800998ecf8427e", ""},
+		{"https://example.com/terraform-provider-foo_1.0.0_linux_amd64.tar.gz", "sha256:" + digest, ""},
+		{"https://example.com/terraform-provider-foo_v1.0.0", "sha256:" + digest, ""},
+		{"https://example.com/terraform-provider-foo_1.0.0_linux_amd64.zip", "", ""},
+	} {
+		t.Run(tc.url+"#"+tc.digest, func(t *testing.T) {
+			plugin := Plugin{Url: tc.url, Digest: tc.digest}
+			if zh := plugin.PackageHashZH(); zh != tc.zh {
+				t.Fatalf("expected '%s', got '%s'", tc.zh, zh)
+			}
+		})
+	}
+}
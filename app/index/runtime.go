@@ -7,14 +7,18 @@ import (
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
 )
 
 type RuntimeIndex struct {
 	platformToFilenameToPlugin map[string]map[string]*Plugin
 	cacheDir                   string
-	openFiles                  map[string]*os.File
+	downloads                  map[string]*pluginDownload // plugins being served or downloaded by path
+	downloadSlots              chan struct{}              // limits parallel downloads, unlimited if nil
 
 	alreadyOpened map[string]int
+	served        map[string]*Plugin
+	reported      bool // whether any lines have been printed yet
 
 	sync.RWMutex
 }
@@ -43,72 +47,182 @@ func (i *RuntimeIndex) LookupPlugin(platform, filename string) *Plugin {
 	return platformPlugins[filename]
 }
 
-func (i RuntimeIndex) getPluginFilePath(plugin *Plugin) string {
+func (i *RuntimeIndex) getPluginFilePath(plugin *Plugin) string {
 	return filepath.Join(i.cacheDir, "plugins", plugin.Kind, plugin.Name, plugin.Version, plugin.Platform)
 }
 
-func (i *RuntimeIndex) OpenPlugin(plugin *Plugin) error {
-	i.Lock()
-	defer i.Unlock()
+// getPartialDir is where partial downloads of plugins are kept so that they can be resumed
+func (i *RuntimeIndex) getPartialDir() string {
+	return filepath.Join(i.cacheDir, "partial")
+}
 
+// OpenPlugin starts serving the plugin - it returns as soon as there is something to read so that uncompressed plugins
+// can be read while they are being downloaded (and other plugins can be opened meanwhile)
+func (i *RuntimeIndex) OpenPlugin(plugin *Plugin) error {
 	path := i.getPluginFilePath(plugin)
 
-	cached := true
-	cachedStateStr := "cached"
+	i.Lock()
+	download, cached, err := i.acquire(plugin, path)
 
-	if verifyPluginSize(path, plugin.Size) != nil {
-		cached = false
+	cachedStateStr := "cached"
+	if !cached {
 		cachedStateStr = "downloading"
 	}
 
-	// Trying to blend in with Terraform output nicely
-	// Would always print 1 extra newline at the end and then rewrite if we believe we're rewriting our content
-	// Just so that there is a nice indentation with other sections
-	lineControl := ""
-	if len(i.alreadyOpened) > 0 {
-		lineControl = "\x1b[1A"
-	}
-
 	if _, ok := i.alreadyOpened[path]; !ok {
-		fmt.Printf(
-			"%s- Para provides 3rd-party Terraform %s plugin '%s' version '%s' for '%s' (%s)\n\n",
-			lineControl, plugin.Kind, plugin.Name, plugin.Version, plugin.Platform, cachedStateStr,
+		i.report(
+			"- Para provides 3rd-party Terraform %s plugin '%s' version '%s' for '%s' (%s)",
+			plugin.Kind, plugin.Name, plugin.Version, plugin.Platform, cachedStateStr,
 		)
+		i.alreadyOpened[path] = 0 // so that it's reported just once
+	}
+	if err != nil {
+		i.Unlock()
+		return err
 	}
 	i.alreadyOpened[path] += 1
+	i.Unlock()
 
-	if !cached {
-		err := utils.DownloadableFile{Url: plugin.Url, ExtractPattern: "terraform-*", Digest: plugin.Digest}.SaveTo(path)
-		if err != nil {
-			fmt.Printf("   * Error reading '%s': %s\n", plugin.Url, err)
-			return err
-		}
-		err = verifyPluginSize(path, plugin.Size)
-		if err != nil {
-			_ = os.Remove(path)
-			fmt.Printf("   * Error reading '%s': %s\n", plugin.Url, err)
-			return err
-		}
-	}
-	reader, err := os.Open(path)
+	// concurrent opens of the same plugin share the download while other plugins are downloaded in parallel
+	err = download.started()
 	if err != nil {
+		i.Lock()
+		if _, offline := err.(*utils.OfflineError); offline {
+			i.report("   * Error: the plugin is not cached and cannot be downloaded in offline mode")
+		} else {
+			i.report("   * Error reading '%s': %s", plugin.Url, err)
+		}
+		i.alreadyOpened[path] -= 1
+		i.release(path)
+		i.Unlock()
 		return err
 	}
-	i.openFiles[path] = reader
+
+	i.Lock()
+	if download.failure() == nil {
+		i.served[path] = plugin // otherwise it's already been reported as failed
+	}
+	i.Unlock()
 
 	return nil
 }
 
+// IsPluginCached tells whether the plugin has been downloaded to the cache dir already (as far as its size tells)
+func (i *RuntimeIndex) IsPluginCached(plugin *Plugin) bool {
+	return verifyPluginSize(i.getPluginFilePath(plugin), plugin.Size) == nil
+}
+
+// CachePlugin makes sure the plugin is downloaded to the cache dir (without opening it) and returns path to it
+func (i *RuntimeIndex) CachePlugin(plugin *Plugin) (string, error) {
+	path := i.getPluginFilePath(plugin)
+
+	i.Lock()
+	download, _, err := i.acquire(plugin, path)
+	i.Unlock()
+	if err != nil {
+		return path, err
+	}
+
+	err = download.wait()
+
+	i.Lock()
+	i.release(path)
+	i.Unlock()
+
+	return path, err
+}
+
+// acquire returns the plugin file being served and tells whether it's been already cached - it starts the download
+// unless it's already cached or in progress. Must be called with the lock held.
+func (i *RuntimeIndex) acquire(plugin *Plugin, path string) (*pluginDownload, bool, error) {
+	download, ok := i.downloads[path]
+	cached := ok && download.isDone()
+	if !ok {
+		if verifyPluginSize(path, plugin.Size) == nil {
+			var err error
+			download, err = openCachedPlugin(path, plugin.Size)
+			if err != nil {
+				return nil, false, err
+			}
+			cached = true
+			now := time.Now()
+			_ = os.Chtimes(path, now, now) // to tell when it was used last time when pruning the cache
+		} else {
+			download = startPluginDownload(plugin, path, i.getPartialDir(), i.downloadSlots, func(err error, streamed bool) {
+				i.Lock()
+				defer i.Unlock()
+				if err != nil {
+					if streamed {
+						// it was opened successfully so the failure would otherwise show up as I/O errors only
+						i.report("   * Error reading '%s': %s", plugin.Url, err)
+					}
+					delete(i.served, path)
+				}
+				i.evict(path)
+			})
+		}
+		i.downloads[path] = download
+	}
+	download.users += 1
+	return download, cached, nil
+}
+
+// report prints a line trying to blend in with Terraform output nicely - it always prints 1 extra newline at the end
+// and then rewrites it with the next line (just so that there is a nice indentation with other sections). Lines of
+// concurrent downloads don't interleave since it must be called with the lock held.
+func (i *RuntimeIndex) report(format string, args ...interface{}) {
+	lineControl := ""
+	if i.reported {
+		lineControl = "\x1b[1A"
+	}
+	fmt.Printf(lineControl+format+"\n\n", args...)
+	i.reported = true
+}
+
+// release must be called with the lock held
+func (i *RuntimeIndex) release(path string) {
+	download, ok := i.downloads[path]
+	if !ok {
+		return
+	}
+	download.users -= 1
+	i.evict(path)
+}
+
+// evict closes the plugin file unless it's still in use or being downloaded (so that a failed download can be retried
+// later on) - must be called with the lock held
+func (i *RuntimeIndex) evict(path string) {
+	download, ok := i.downloads[path]
+	if !ok || download.users > 0 || !download.isDone() {
+		return
+	}
+	delete(i.downloads, path)
+	_ = download.close()
+}
+
+// ListServedPlugins returns all plugins that were successfully opened at least once
+func (i *RuntimeIndex) ListServedPlugins() []*Plugin {
+	i.RLock()
+	defer i.RUnlock()
+
+	var result []*Plugin
+	for _, p := range i.served {
+		result = append(result, p)
+	}
+	return result
+}
+
+// GetReaderAt returns a reader for an opened plugin - reads wait for the data if it's still being downloaded
 func (i *RuntimeIndex) GetReaderAt(plugin *Plugin) (io.ReaderAt, error) {
 	i.RLock()
 	defer i.RUnlock()
 
 	path := i.getPluginFilePath(plugin)
-	reader, ok := i.openFiles[path]
+	download, ok := i.downloads[path]
 	if !ok {
 		return nil, fmt.Errorf("plugin file '%s' platform has not been opened yet", path)
 	}
-	return reader, nil
+	return download, nil
 }
 
 func (i *RuntimeIndex) ClosePlugin(plugin *Plugin) error {
@@ -118,17 +232,43 @@ func (i *RuntimeIndex) ClosePlugin(plugin *Plugin) error {
 	path := i.getPluginFilePath(plugin)
 
 	i.alreadyOpened[path] -= 1
-	if i.alreadyOpened[path] > 0 {
-		return nil // still in use
+	i.release(path)
+	return nil
+}
+
+// downloadPlugin waits for other processes sharing the cache dir if they are downloading the same plugin and then for a
+// free download slot (if slots are limited) unless the plugin got cached meanwhile
+func downloadPlugin(
+	plugin *Plugin, path, partialDir string, slots chan struct{}, progress func(part *os.File, size int64),
+) error {
+	lock, err := utils.LockCacheEntry(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = lock.Unlock() }()
+	if verifyPluginSize(path, plugin.Size) == nil {
+		return nil // downloaded by another process
 	}
 
-	defer delete(i.openFiles, path)
-	reader, ok := i.openFiles[path]
-	if ok {
-		err := reader.Close()
-		if err != nil {
-			return err
-		}
+	if slots != nil {
+		slots <- struct{}{}
+		defer func() { <-slots }()
+	}
+
+	err = utils.DownloadableFile{
+		Url:            plugin.Url,
+		ExtractPattern: "terraform-*",
+		Digest:         plugin.Digest,
+		PartialDir:     partialDir,
+		Progress:       progress,
+	}.SaveTo(path)
+	if err != nil {
+		return err
+	}
+	err = verifyPluginSize(path, plugin.Size)
+	if err != nil {
+		_ = os.Remove(path)
+		return err
 	}
 	return nil
 }
@@ -0,0 +1,75 @@
+package index
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestRuntimeIndexOpenPlugin(t *testing.T) {
+	dir := t.TempDir()
+	content := []byte("#!/bin/sh\n")
+	source := filepath.Join(dir, "terraform-provider-foo")
+	if err := ioutil.WriteFile(source, content, 0755); err != nil {
+		t.Fatal(err)
+	}
+	document := fmt.Sprintf(`
+provider:
+  foo:
+    v1.0.0:
+      linux_amd64: {url: "%s", size: %d, digest: "sha256:%x"}
+  broken:
+    v1.0.0:
+      linux_amd64: {url: "%s", size: 1, digest: "sha256:00"}
+`, source, len(content), sha256.Sum256(content), filepath.Join(dir, "missing"))
+	runtimeIndex := loadTestIndex(t, document).BuildRuntimeIndex(0)
+	foo := runtimeIndex.LookupPlugin("linux_amd64", "terraform-provider-foo_v1.0.0")
+	broken := runtimeIndex.LookupPlugin("linux_amd64", "terraform-provider-broken_v1.0.0")
+	if foo == nil || broken == nil {
+		t.Fatal("plugins should be listed")
+	}
+
+	// failed opens must not count as opened since they are never closed
+	for attempt := 0; attempt < 2; attempt++ {
+		if err := runtimeIndex.OpenPlugin(broken); err == nil {
+			t.Fatal("missing plugin should fail to open")
+		}
+	}
+	if opened := runtimeIndex.alreadyOpened[runtimeIndex.getPluginFilePath(broken)]; opened != 0 {
+		t.Fatalf("failed opens should not be counted, got %d", opened)
+	}
+	if len(runtimeIndex.ListServedPlugins()) != 0 {
+		t.Fatal("failed plugins should not be listed as served")
+	}
+
+	for attempt := 0; attempt < 2; attempt++ {
+		if err := runtimeIndex.OpenPlugin(foo); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fooPath := runtimeIndex.getPluginFilePath(foo)
+	if opened := runtimeIndex.alreadyOpened[fooPath]; opened != 2 {
+		t.Fatalf("expected 2 opens, got %d", opened)
+	}
+	reader, err := runtimeIndex.GetReaderAt(foo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buffer := make([]byte, len(content))
+	if _, err := reader.ReadAt(buffer, 0); err != nil || string(buffer) != string(content) {
+		t.Fatalf("unexpected content '%s' (%v)", buffer, err)
+	}
+	for attempt := 0; attempt < 2; attempt++ {
+		if err := runtimeIndex.ClosePlugin(foo); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if opened := runtimeIndex.alreadyOpened[fooPath]; opened != 0 {
+		t.Fatalf("expected all opens to be closed, got %d", opened)
+	}
+	if served := runtimeIndex.ListServedPlugins(); len(served) != 1 || served[0] != foo {
+		t.Fatalf("expected foo to be served, got %v", served)
+	}
+}
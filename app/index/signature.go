@@ -0,0 +1,74 @@
+package index
+
+import (
+	"fmt"
+	"github.com/paraterraform/para/utils"
+	"strings"
+	"time"
+)
+
+// Detached signatures are looked up next to the signed file
+var signatureSuffixes = []string{".sig", ".asc"}
+
+// SignaturePolicy controls verification of detached OpenPGP signatures of the primary index and of extension files
+// referenced by URLs
+type SignaturePolicy struct {
+	KeyRing  *utils.PgpKeyRing
+	Required bool
+}
+
+// SignatureError means that a signature is present but invalid, that it's required but missing or that it can't be
+// told whether it's there
+type SignatureError struct {
+	Location    string
+	Missing     bool
+	Unavailable bool
+	Reason      error
+}
+
+func (e *SignatureError) Error() string {
+	if e.Missing {
+		return fmt.Sprintf("'%s' is not signed but signatures are required: %s", e.Location, e.Reason)
+	}
+	if e.Unavailable {
+		return fmt.Sprintf("cannot fetch signature of '%s': %s", e.Location, e.Reason)
+	}
+	return fmt.Sprintf("'%s' has an invalid signature: %s", e.Location, e.Reason)
+}
+
+// verify returns fingerprint of the signer or an empty string if content is not signed and it's allowed
+func (p *SignaturePolicy) verify(location string, content []byte, cacheDir string, refresh time.Duration) (string, error) {
+	if p == nil || p.KeyRing.IsEmpty() {
+		return "", nil
+	}
+
+	var tried []string
+	for _, suffix := range signatureSuffixes {
+		signatureLocation := location + suffix
+		tried = append(tried, signatureLocation)
+
+		signature, _, err := utils.DownloadableFile{Url: signatureLocation}.ReadAllWithCache(cacheDir, refresh)
+		if utils.IsNotFound(err) {
+			continue // signature is not there - try the next one
+		}
+		if err != nil {
+			// a signature that failed to be fetched may well be there - assuming it's not would make stripping it off
+			// as easy as breaking the connection
+			return "", &SignatureError{Location: location, Unavailable: true, Reason: err}
+		}
+		signer, err := p.KeyRing.VerifyDetached(content, signature)
+		if err != nil {
+			return "", &SignatureError{Location: location, Reason: err}
+		}
+		return signer, nil
+	}
+
+	if p.Required {
+		return "", &SignatureError{
+			Location: location,
+			Missing:  true,
+			Reason:   fmt.Errorf("none found at %s", strings.Join(tried, ", ")),
+		}
+	}
+	return "", nil
+}
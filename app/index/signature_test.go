@@ -0,0 +1,87 @@
+package index
+
+import (
+	"github.com/paraterraform/para/utils"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestSignaturePolicyVerify(t *testing.T) {
+	testData := filepath.Join("..", "..", "utils", "testdata", "openpgp")
+	key, err := ioutil.ReadFile(filepath.Join(testData, "alice.asc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	message, err := ioutil.ReadFile(filepath.Join(testData, "message.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature, err := ioutil.ReadFile(filepath.Join(testData, "alice.sig"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyRing := &utils.PgpKeyRing{}
+	if err := keyRing.Add(key); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() { _ = utils.SetHttpOptions(utils.DefaultHttpOptions) }()
+	options := utils.DefaultHttpOptions
+	options.Retries = 0
+	if err := utils.SetHttpOptions(options); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		status   int // of the .sig
+		required bool
+		signer   string
+		outcome  func(err *SignatureError) bool
+	}{
+		{"signed", http.StatusOK, false, "2DDF47EFD720A9F1FD48C9ACCC6B417D2D8AFC04", nil},
+		{"not signed", http.StatusNotFound, false, "", nil},
+		{"not signed but required", http.StatusNotFound, true, "", func(err *SignatureError) bool {
+			return err.Missing
+		}},
+		{"server error", http.StatusInternalServerError, false, "", func(err *SignatureError) bool {
+			return err.Unavailable
+		}},
+		{"forbidden", http.StatusForbidden, false, "", func(err *SignatureError) bool {
+			return err.Unavailable
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.URL.Path == "/index.yaml.sig" && tc.status == http.StatusOK:
+					_, _ = w.Write(signature)
+				case r.URL.Path == "/index.yaml.sig":
+					w.WriteHeader(tc.status)
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			defer server.Close()
+
+			policy := &SignaturePolicy{KeyRing: keyRing, Required: tc.required}
+			signer, err := policy.verify(server.URL+"/index.yaml", message, t.TempDir(), 0)
+			if tc.outcome == nil {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				if signer != tc.signer {
+					t.Fatalf("expected signer '%s', got '%s'", tc.signer, signer)
+				}
+				return
+			}
+			signatureErr, ok := err.(*SignatureError)
+			if !ok || !tc.outcome(signatureErr) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package index
+
+import (
+	"os"
+	"sync"
+)
+
+// pluginDownload is a plugin file being served - either right from the cache or while it's being downloaded in which
+// case reads beyond what has been downloaded so far wait for more data (or for the download to complete)
+type pluginDownload struct {
+	size  uint64
+	users int // how many times it's been opened but not closed yet, guarded by the runtime index
+
+	cond *sync.Cond // guards all fields below and signals progress
+
+	part      *os.File   // being written by the download
+	reader    *os.File   // the partial download until it's complete, then the cached file
+	readers   []*os.File // all ever opened, closed together once the plugin is not served anymore
+	available int64      // how much can be read from the reader
+	streaming bool       // whether the data became available before the download completed
+	done      bool
+	err       error // fails all reads once set
+}
+
+func openCachedPlugin(path string, size uint64) (*pluginDownload, error) {
+	reader, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &pluginDownload{
+		size:      size,
+		cond:      sync.NewCond(&sync.Mutex{}),
+		reader:    reader,
+		readers:   []*os.File{reader},
+		available: int64(size),
+		done:      true,
+	}, nil
+}
+
+// startPluginDownload downloads the plugin in background, the callback is called once it's finished either way
+func startPluginDownload(
+	plugin *Plugin, path, partialDir string, slots chan struct{}, finished func(err error, streamed bool),
+) *pluginDownload {
+	d := &pluginDownload{size: plugin.Size, cond: sync.NewCond(&sync.Mutex{})}
+	go func() {
+		err := downloadPlugin(plugin, path, partialDir, slots, d.progress)
+		finished(err, d.finish(path, err))
+	}()
+	return d
+}
+
+func (d *pluginDownload) progress(part *os.File, size int64) {
+	d.cond.L.Lock()
+	defer d.cond.L.Unlock()
+
+	if part != d.part {
+		// the download (re)started into a new file - it's the same path but may not be the same file anymore
+		reader, err := os.Open(part.Name())
+		if err != nil {
+			return // cannot stream it - reads will wait for the download to complete
+		}
+		d.part = part
+		d.reader = reader
+		d.readers = append(d.readers, reader)
+		d.streaming = true
+	}
+	d.available = size
+	d.cond.Broadcast()
+}
+
+// finish switches reads over to the cached file (or fails them) and tells whether any data was available before
+func (d *pluginDownload) finish(path string, err error) bool {
+	d.cond.L.Lock()
+	defer d.cond.L.Unlock()
+
+	if err == nil {
+		var reader *os.File
+		reader, err = os.Open(path)
+		if err == nil {
+			d.reader = reader
+			d.readers = append(d.readers, reader)
+			d.available = int64(d.size)
+		}
+	}
+	d.err = err
+	d.done = true
+	d.cond.Broadcast()
+	return d.streaming
+}
+
+// started waits until there is something to read or the download fails
+func (d *pluginDownload) started() error {
+	d.cond.L.Lock()
+	defer d.cond.L.Unlock()
+
+	for !d.done && !d.streaming {
+		d.cond.Wait()
+	}
+	return d.err
+}
+
+// wait waits until the download completes
+func (d *pluginDownload) wait() error {
+	d.cond.L.Lock()
+	defer d.cond.L.Unlock()
+
+	for !d.done {
+		d.cond.Wait()
+	}
+	return d.err
+}
+
+func (d *pluginDownload) failure() error {
+	d.cond.L.Lock()
+	defer d.cond.L.Unlock()
+
+	return d.err
+}
+
+func (d *pluginDownload) isDone() bool {
+	d.cond.L.Lock()
+	defer d.cond.L.Unlock()
+
+	return d.done
+}
+
+// ReadAt waits until requested range is downloaded and fails if the download fails (even if the range was already
+// downloaded since the data cannot be trusted). Reads reaching the end of the file wait for the download to complete
+// and to be verified so that nobody ever gets the whole file before it's known to be intact.
+func (d *pluginDownload) ReadAt(p []byte, off int64) (int, error) {
+	end := off + int64(len(p))
+	if end > int64(d.size) {
+		end = int64(d.size)
+	}
+	final := end == int64(d.size)
+
+	d.cond.L.Lock()
+	for !d.done && (final || d.reader == nil || d.available < end) {
+		d.cond.Wait()
+	}
+	reader, err := d.reader, d.err
+	d.cond.L.Unlock()
+
+	if err != nil {
+		return 0, err
+	}
+	return reader.ReadAt(p, off)
+}
+
+// close must not be called while there are still reads in progress
+func (d *pluginDownload) close() error {
+	d.cond.L.Lock()
+	defer d.cond.L.Unlock()
+
+	var result error
+	for _, reader := range d.readers {
+		err := reader.Close()
+		if err != nil && result == nil {
+			result = err
+		}
+	}
+	d.readers = nil
+	return result
+}
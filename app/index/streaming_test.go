@@ -0,0 +1,148 @@
+package index
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+const streamingTestContent = "0123456789abcdefghij"
+
+type streamingTestRead struct {
+	data string
+	err  error
+}
+
+// readInBackground starts a read and returns a channel that gets its outcome
+func readInBackground(d *pluginDownload, length int, off int64) chan streamingTestRead {
+	result := make(chan streamingTestRead, 1)
+	go func() {
+		buffer := make([]byte, length)
+		n, err := d.ReadAt(buffer, off)
+		result <- streamingTestRead{string(buffer[:n]), err}
+	}()
+	return result
+}
+
+func expectBlocked(t *testing.T, read chan streamingTestRead) {
+	select {
+	case outcome := <-read:
+		t.Fatalf("read should wait but returned '%s' (%v)", outcome.data, outcome.err)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func expectRead(t *testing.T, read chan streamingTestRead, data string) {
+	select {
+	case outcome := <-read:
+		if (outcome.err != nil && outcome.err != io.EOF) || outcome.data != data {
+			t.Fatalf("expected '%s', got '%s' (%v)", data, outcome.data, outcome.err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("read should not wait")
+	}
+}
+
+func expectFailed(t *testing.T, read chan streamingTestRead) {
+	select {
+	case outcome := <-read:
+		if outcome.err == nil {
+			t.Fatalf("read should fail but returned '%s'", outcome.data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("read should not wait")
+	}
+}
+
+// startStreamingTest writes the first part of the content as if it was being downloaded
+func startStreamingTest(t *testing.T, downloaded int) (*pluginDownload, *os.File, string) {
+	dir := t.TempDir()
+	part, err := os.Create(filepath.Join(dir, "plugin.part"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = part.Close() })
+	d := &pluginDownload{size: uint64(len(streamingTestContent)), cond: sync.NewCond(&sync.Mutex{})}
+	t.Cleanup(func() { _ = d.close() })
+
+	if _, err := part.WriteString(streamingTestContent[:downloaded]); err != nil {
+		t.Fatal(err)
+	}
+	d.progress(part, int64(downloaded))
+	return d, part, filepath.Join(dir, "plugin")
+}
+
+func TestPluginDownloadStreaming(t *testing.T) {
+	d, part, path := startStreamingTest(t, 10)
+	if err := d.started(); err != nil {
+		t.Fatal(err)
+	}
+
+	expectRead(t, readInBackground(d, 5, 2), "23456")
+	beyond := readInBackground(d, 5, 8)
+	final := readInBackground(d, 10, 10)
+	past := readInBackground(d, 10, 15) // reaches the end too
+	expectBlocked(t, beyond)
+	expectBlocked(t, final)
+	expectBlocked(t, past)
+
+	// everything but the last byte
+	if _, err := part.WriteString(streamingTestContent[10:19]); err != nil {
+		t.Fatal(err)
+	}
+	d.progress(part, 19)
+	expectRead(t, beyond, "89abc")
+	expectRead(t, readInBackground(d, 9, 10), "abcdefghi")
+	expectBlocked(t, final)
+
+	// the complete file is there but it's not verified yet
+	if _, err := part.WriteString(streamingTestContent[19:]); err != nil {
+		t.Fatal(err)
+	}
+	d.progress(part, 20)
+	expectBlocked(t, final)
+	expectBlocked(t, past)
+
+	// verified and moved into the cache
+	if err := ioutil.WriteFile(path, []byte(streamingTestContent), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if d.finish(path, nil) != true {
+		t.Fatal("the data was available before the download completed")
+	}
+	expectRead(t, final, "abcdefghij")
+	expectRead(t, past, "fghij")
+}
+
+func TestPluginDownloadStreamingFailed(t *testing.T) {
+	d, _, path := startStreamingTest(t, len(streamingTestContent))
+
+	expectRead(t, readInBackground(d, 5, 0), "01234")
+	final := readInBackground(d, 5, 15)
+	expectBlocked(t, final)
+
+	d.finish(path, errors.New("digest mismatch"))
+	expectFailed(t, final)
+	expectFailed(t, readInBackground(d, 5, 0)) // the data cannot be trusted anymore
+	if err := d.wait(); err == nil {
+		t.Fatal("the download should have failed")
+	}
+}
+
+func TestPluginDownloadCached(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "plugin")
+	if err := ioutil.WriteFile(path, []byte(streamingTestContent), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d, err := openCachedPlugin(path, uint64(len(streamingTestContent)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = d.close() }()
+	expectRead(t, readInBackground(d, 5, 15), "fghij")
+}
@@ -0,0 +1,359 @@
+package index
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Version is a parsed semantic version like v1.2.3 or v1.2.3-beta1 (build metadata is kept but ignored when ordering)
+type Version struct {
+	Major      uint64
+	Minor      uint64
+	Patch      uint64
+	Prerelease string
+	Metadata   string
+
+	raw string
+}
+
+var versionRe = regexp.MustCompile(
+	`^v?(0|[1-9]\d*)\.(0|[1-9]\d*)\.(0|[1-9]\d*)(?:-([0-9A-Za-z.-]+))?(?:\+([0-9A-Za-z.-]+))?$`,
+)
+
+// ParseVersion parses a version in the format of [v]X.Y.Z[-pre][+meta] (all 3 numeric segments are required)
+func ParseVersion(raw string) (*Version, error) {
+	match := versionRe.FindStringSubmatch(strings.TrimSpace(raw))
+	if match == nil {
+		return nil, fmt.Errorf("malformed version '%s': does not match expected pattern of vX.Y.Z[-pre]", raw)
+	}
+
+	segments := make([]uint64, 3)
+	for idx := range segments {
+		value, err := strconv.ParseUint(match[idx+1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("malformed version '%s': %s", raw, err)
+		}
+		segments[idx] = value
+	}
+
+	return &Version{
+		Major:      segments[0],
+		Minor:      segments[1],
+		Patch:      segments[2],
+		Prerelease: match[4],
+		Metadata:   match[5],
+		raw:        raw,
+	}, nil
+}
+
+// MustParseVersion is like ParseVersion but panics if the version cannot be parsed
+func MustParseVersion(raw string) *Version {
+	v, err := ParseVersion(raw)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// String returns the version exactly as it was given to ParseVersion
+func (v *Version) String() string {
+	return v.raw
+}
+
+// Core returns the version in the canonical X.Y.Z[-pre] format (without "v" prefix or metadata)
+func (v *Version) Core() string {
+	core := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.Prerelease != "" {
+		core += "-" + v.Prerelease
+	}
+	return core
+}
+
+func (v *Version) IsPrerelease() bool {
+	return v.Prerelease != ""
+}
+
+// Compare returns -1, 0 or 1 if the version is lower, equal or greater than the other one as per semver rules
+func (v *Version) Compare(other *Version) int {
+	if c := compareUint(v.Major, other.Major); c != 0 {
+		return c
+	}
+	if c := compareUint(v.Minor, other.Minor); c != 0 {
+		return c
+	}
+	if c := compareUint(v.Patch, other.Patch); c != 0 {
+		return c
+	}
+	return comparePrerelease(v.Prerelease, other.Prerelease)
+}
+
+func (v *Version) Equal(other *Version) bool {
+	return v.Compare(other) == 0
+}
+
+func (v *Version) LessThan(other *Version) bool {
+	return v.Compare(other) < 0
+}
+
+func (v *Version) GreaterThan(other *Version) bool {
+	return v.Compare(other) > 0
+}
+
+func compareUint(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// comparePrerelease follows https://semver.org/#spec-item-11 - a release is always greater than its pre-release
+func comparePrerelease(a, b string) int {
+	if a == b {
+		return 0
+	}
+	if a == "" {
+		return 1
+	}
+	if b == "" {
+		return -1
+	}
+
+	aIds := strings.Split(a, ".")
+	bIds := strings.Split(b, ".")
+	for idx := 0; idx < len(aIds) && idx < len(bIds); idx++ {
+		aNum, aErr := strconv.ParseUint(aIds[idx], 10, 64)
+		bNum, bErr := strconv.ParseUint(bIds[idx], 10, 64)
+		switch {
+		case aErr == nil && bErr == nil:
+			if c := compareUint(aNum, bNum); c != 0 {
+				return c
+			}
+		case aErr == nil:
+			return -1 // numeric identifiers always have lower precedence than alphanumeric ones
+		case bErr == nil:
+			return 1
+		default:
+			if c := strings.Compare(aIds[idx], bIds[idx]); c != 0 {
+				return c
+			}
+		}
+	}
+	return compareUint(uint64(len(aIds)), uint64(len(bIds)))
+}
+
+// Versions implements sort.Interface and sorts from the oldest to the newest
+type Versions []*Version
+
+func (vs Versions) Len() int {
+	return len(vs)
+}
+
+func (vs Versions) Less(i, j int) bool {
+	return vs[i].LessThan(vs[j])
+}
+
+func (vs Versions) Swap(i, j int) {
+	vs[i], vs[j] = vs[j], vs[i]
+}
+
+// Latest returns the newest version that is not a pre-release or nil if there is none
+func (vs Versions) Latest() *Version {
+	var latest *Version
+	for _, v := range vs {
+		if v.IsPrerelease() {
+			continue
+		}
+		if latest == nil || v.GreaterThan(latest) {
+			latest = v
+		}
+	}
+	return latest
+}
+
+// Constraint operators, compatible with the ones used by Terraform
+const (
+	opEqual          = "="
+	opNotEqual       = "!="
+	opGreater        = ">"
+	opGreaterOrEqual = ">="
+	opLess           = "<"
+	opLessOrEqual    = "<="
+	opPessimistic    = "~>"
+)
+
+var constraintRe = regexp.MustCompile(
+	`^(=|!=|>=|<=|>|<|~>)?\s*v?(0|[1-9]\d*)(?:\.(0|[1-9]\d*))?(?:\.(0|[1-9]\d*))?(?:-([0-9A-Za-z.-]+))?$`,
+)
+
+// Constraint is a single operator applied to a (possibly partial) version, e.g. "~> 1.2" or ">= 0.4.0"
+type Constraint struct {
+	operator string
+	version  *Version
+	segments int // how many numeric segments were given explicitly, matters for "~>"
+	raw      string
+}
+
+// Constraints is a set of constraints that must be satisfied all at once, e.g. ">= 0.4, < 0.6"
+type Constraints []*Constraint
+
+// ParseConstraints parses a comma-separated list of constraints
+func ParseConstraints(raw string) (Constraints, error) {
+	var result Constraints
+	for _, token := range strings.Split(raw, ",") {
+		c, err := parseConstraint(strings.TrimSpace(token))
+		if err != nil {
+			return nil, fmt.Errorf("malformed version constraint '%s': %s", raw, err)
+		}
+		result = append(result, c)
+	}
+	return result, nil
+}
+
+func parseConstraint(raw string) (*Constraint, error) {
+	match := constraintRe.FindStringSubmatch(raw)
+	if match == nil {
+		return nil, fmt.Errorf("'%s' does not match expected pattern of [<op>] X[.Y[.Z]][-pre]", raw)
+	}
+
+	operator := match[1]
+	if operator == "" {
+		operator = opEqual
+	}
+
+	segments := 1
+	padded := []string{match[2], "0", "0"}
+	for idx, segment := range match[3:5] {
+		if segment == "" {
+			break
+		}
+		padded[idx+1] = segment
+		segments += 1
+	}
+	if match[5] != "" && segments < 3 {
+		return nil, fmt.Errorf("'%s' has a pre-release but does not specify all of X.Y.Z", raw)
+	}
+	if operator == opPessimistic && segments < 2 {
+		return nil, fmt.Errorf("'%s' must specify at least X.Y when used with %s", raw, opPessimistic)
+	}
+
+	coreRaw := strings.Join(padded, ".")
+	if match[5] != "" {
+		coreRaw += "-" + match[5]
+	}
+	version, err := ParseVersion(coreRaw)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Constraint{operator: operator, version: version, segments: segments, raw: raw}, nil
+}
+
+func (c *Constraint) String() string {
+	return c.raw
+}
+
+func (c *Constraint) Check(v *Version) bool {
+	cmp := v.Compare(c.version)
+	switch c.operator {
+	case opEqual:
+		return cmp == 0
+	case opNotEqual:
+		return cmp != 0
+	case opGreater:
+		return cmp > 0
+	case opGreaterOrEqual:
+		return cmp >= 0
+	case opLess:
+		return cmp < 0
+	case opLessOrEqual:
+		return cmp <= 0
+	case opPessimistic:
+		if cmp < 0 {
+			return false
+		}
+		// "~> X.Y" allows anything within X.*, "~> X.Y.Z" allows anything within X.Y.*
+		if c.segments == 2 {
+			return v.Major == c.version.Major
+		}
+		return v.Major == c.version.Major && v.Minor == c.version.Minor
+	}
+	return false
+}
+
+func (cs Constraints) String() string {
+	var tokens []string
+	for _, c := range cs {
+		tokens = append(tokens, c.String())
+	}
+	return strings.Join(tokens, ", ")
+}
+
+// Check reports whether the version satisfies all constraints. Pre-releases are only considered when one of the
+// constraints explicitly names a pre-release of the same X.Y.Z (same as Terraform does).
+func (cs Constraints) Check(v *Version) bool {
+	if v.IsPrerelease() && !cs.allowsPrerelease(v) {
+		return false
+	}
+	for _, c := range cs {
+		if !c.Check(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// IncludesPrerelease reports whether any of the constraints explicitly names a pre-release
+func (cs Constraints) IncludesPrerelease() bool {
+	for _, c := range cs {
+		if c.version.IsPrerelease() {
+			return true
+		}
+	}
+	return false
+}
+
+func (cs Constraints) allowsPrerelease(v *Version) bool {
+	for _, c := range cs {
+		cv := c.version
+		if cv.IsPrerelease() && cv.Major == v.Major && cv.Minor == v.Minor && cv.Patch == v.Patch {
+			return true
+		}
+	}
+	return false
+}
+
+// Filter returns all versions satisfying the constraints sorted from the oldest to the newest
+func (cs Constraints) Filter(versions Versions) Versions {
+	var result Versions
+	for _, v := range versions {
+		if cs.Check(v) {
+			result = append(result, v)
+		}
+	}
+	sort.Sort(result)
+	return result
+}
+
+// Latest returns the newest version satisfying the constraints or nil if there is none
+func (cs Constraints) Latest(versions Versions) *Version {
+	matching := cs.Filter(versions)
+	if len(matching) == 0 {
+		return nil
+	}
+	return matching[len(matching)-1]
+}
+
+// Exact returns the version if constraints allow just a single version given in full (e.g. "1.2.3" or "= 1.2.3")
+func (cs Constraints) Exact() *Version {
+	if len(cs) != 1 || cs[0].operator != opEqual || cs[0].segments != 3 {
+		return nil
+	}
+	return cs[0].version
+}
@@ -0,0 +1,194 @@
+package index
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestParseVersion(t *testing.T) {
+	for _, tc := range []struct {
+		raw  string
+		core string // empty if the version is malformed
+	}{
+		{"v1.2.3", "1.2.3"},
+		{"1.2.3", "1.2.3"},
+		{" v0.0.1 ", "0.0.1"},
+		{"v1.2.3-beta1", "1.2.3-beta1"},
+		{"v1.2.3-rc.1+build.5", "1.2.3-rc.1"},
+		{"v1.2.3+20190101", "1.2.3"},
+		{"v1.2", ""},
+		{"v1", ""},
+		{"v01.2.3", ""},
+		{"v1.2.3-", ""},
+		{"v1.2.3-beta_1", ""},
+		{"latest", ""},
+		{"", ""},
+		{"v99999999999999999999.0.0", ""},
+	} {
+		t.Run(tc.raw, func(t *testing.T) {
+			v, err := ParseVersion(tc.raw)
+			if tc.core == "" {
+				if err == nil {
+					t.Fatalf("expected '%s' to be malformed, got %s", tc.raw, v.Core())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v.Core() != tc.core {
+				t.Fatalf("expected %s, got %s", tc.core, v.Core())
+			}
+			if v.String() != tc.raw {
+				t.Fatalf("expected String() to return '%s' as given, got '%s'", tc.raw, v.String())
+			}
+		})
+	}
+}
+
+func TestVersionOrdering(t *testing.T) {
+	// from the oldest to the newest as per https://semver.org/#spec-item-11
+	ordered := []string{
+		"v0.9.9",
+		"v1.0.0-alpha",
+		"v1.0.0-alpha.1",
+		"v1.0.0-alpha.beta",
+		"v1.0.0-beta",
+		"v1.0.0-beta.2",
+		"v1.0.0-beta.11",
+		"v1.0.0-rc.1",
+		"v1.0.0",
+		"v1.0.1",
+		"v1.2.0",
+		"v1.10.0",
+		"v2.0.0",
+	}
+	var versions Versions
+	for idx := len(ordered) - 1; idx >= 0; idx-- {
+		versions = append(versions, MustParseVersion(ordered[idx]))
+	}
+	sort.Sort(versions)
+	for idx, v := range versions {
+		if v.String() != ordered[idx] {
+			t.Fatalf("expected %s at position %d, got %s", ordered[idx], idx, v)
+		}
+	}
+
+	if !MustParseVersion("v1.2.3+a").Equal(MustParseVersion("1.2.3+b")) {
+		t.Fatal("build metadata and the prefix must be ignored when comparing")
+	}
+	if latest := versions.Latest(); latest.String() != "v2.0.0" {
+		t.Fatalf("expected the latest release to be v2.0.0, got %s", latest)
+	}
+	withPrerelease := Versions{MustParseVersion("v1.0.0"), MustParseVersion("v2.0.0-rc1")}
+	if latest := withPrerelease.Latest(); latest.String() != "v1.0.0" {
+		t.Fatalf("pre-releases must never be the latest, got %s", latest)
+	}
+	if (Versions{MustParseVersion("v2.0.0-rc1")}).Latest() != nil {
+		t.Fatal("there is no latest version if all of them are pre-releases")
+	}
+}
+
+func TestConstraintsCheck(t *testing.T) {
+	for _, tc := range []struct {
+		constraints string
+		matching    []string
+		other       []string
+	}{
+		{"1.2.3", []string{"v1.2.3", "1.2.3+meta"}, []string{"v1.2.4", "v1.2.3-beta"}},
+		{"= 1.2", []string{"v1.2.0"}, []string{"v1.2.1"}},
+		{"!= 1.2.3", []string{"v1.2.2", "v1.2.4"}, []string{"v1.2.3", "v1.2.4-beta"}},
+		{"> 1.2.3", []string{"v1.2.4", "v2.0.0"}, []string{"v1.2.3", "v1.0.0", "v1.3.0-beta"}},
+		{">= 1.2.3", []string{"v1.2.3", "v1.3.0"}, []string{"v1.2.2"}},
+		{"< 1.2.3", []string{"v1.2.2", "v0.1.0"}, []string{"v1.2.3", "v1.2.3-beta"}},
+		{"<= 1.2.3", []string{"v1.2.3", "v1.2.2"}, []string{"v1.2.4"}},
+		{"~> 1.2", []string{"v1.2.0", "v1.9.9"}, []string{"v1.1.9", "v2.0.0", "v1.3.0-beta"}},
+		{"~> 1.2.3", []string{"v1.2.3", "v1.2.99"}, []string{"v1.2.2", "v1.3.0"}},
+		{"~> 0.12.0", []string{"v0.12.0", "v0.12.31"}, []string{"v0.13.0", "v0.11.14"}},
+		{">= 0.4, < 0.6", []string{"v0.4.0", "v0.5.9"}, []string{"v0.3.9", "v0.6.0"}},
+		{">= 1.0, != 1.5.0", []string{"v1.4.9", "v1.5.1"}, []string{"v1.5.0", "v0.9.0"}},
+		{"1.2.3-beta1", []string{"v1.2.3-beta1"}, []string{"v1.2.3", "v1.2.3-beta2"}},
+		{">= 1.2.3-beta1", []string{"v1.2.3-beta2", "v1.2.3", "v1.3.0"}, []string{"v1.2.3-alpha", "v1.3.0-beta"}},
+		{"~> 1.2.3-beta1", []string{"v1.2.3-rc1", "v1.2.4"}, []string{"v1.2.4-beta", "v1.3.0"}},
+	} {
+		t.Run(tc.constraints, func(t *testing.T) {
+			constraints, err := ParseConstraints(tc.constraints)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, raw := range tc.matching {
+				if !constraints.Check(MustParseVersion(raw)) {
+					t.Errorf("expected %s to satisfy '%s'", raw, tc.constraints)
+				}
+			}
+			for _, raw := range tc.other {
+				if constraints.Check(MustParseVersion(raw)) {
+					t.Errorf("expected %s not to satisfy '%s'", raw, tc.constraints)
+				}
+			}
+		})
+	}
+}
+
+func TestParseConstraintsMalformed(t *testing.T) {
+	for _, tc := range []struct {
+		raw string
+		err string
+	}{
+		{"", "does not match expected pattern"},
+		{"latest", "does not match expected pattern"},
+		{">= 1.2,", "does not match expected pattern"},
+		{"=> 1.2", "does not match expected pattern"},
+		{"~> 1", "must specify at least X.Y"},
+		{"1.2-beta", "does not specify all of X.Y.Z"},
+		{"1.2.3.4", "does not match expected pattern"},
+	} {
+		t.Run(tc.raw, func(t *testing.T) {
+			_, err := ParseConstraints(tc.raw)
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Fatalf("expected error containing '%s', got %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestConstraintsLatestAndExact(t *testing.T) {
+	versions := Versions{
+		MustParseVersion("v0.11.14"),
+		MustParseVersion("v0.12.29"),
+		MustParseVersion("v0.12.31"),
+		MustParseVersion("v0.13.0-rc1"),
+		MustParseVersion("v0.13.0"),
+	}
+	for _, tc := range []struct {
+		constraints string
+		latest      string // empty if nothing matches
+		exact       bool
+	}{
+		{"~> 0.12.0", "v0.12.31", false},
+		{"< 0.13", "v0.12.31", false},
+		{">= 0.11", "v0.13.0", false},
+		{"0.13.0-rc1", "v0.13.0-rc1", true},
+		{"= 0.12.29", "v0.12.29", true},
+		{"0.12", "", false},
+		{"> 1.0", "", false},
+	} {
+		t.Run(tc.constraints, func(t *testing.T) {
+			constraints, err := ParseConstraints(tc.constraints)
+			if err != nil {
+				t.Fatal(err)
+			}
+			latest := constraints.Latest(versions)
+			switch {
+			case tc.latest == "" && latest != nil:
+				t.Fatalf("expected nothing to match, got %s", latest)
+			case tc.latest != "" && (latest == nil || latest.String() != tc.latest):
+				t.Fatalf("expected %s, got %v", tc.latest, latest)
+			}
+			if (constraints.Exact() != nil) != tc.exact {
+				t.Fatalf("expected Exact() to be %v", tc.exact)
+			}
+		})
+	}
+}
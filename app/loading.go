@@ -0,0 +1,255 @@
+package app
+
+import (
+	"fmt"
+	"github.com/mitchellh/go-homedir"
+	"github.com/paraterraform/para/app/index"
+	"github.com/paraterraform/para/utils"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// IndexConfig describes where Para discovers plugin indices and how it narrows them down for a given project
+type IndexConfig struct {
+	PrimaryCandidates []string
+	PrimaryLayers     []string // primary indices to merge (later ones take precedence) instead of picking a candidate
+	Merge             string   // what later primary indices override: whole plugins or just versions they list
+	Extensions        []string
+	Pins              map[string]string
+	PinLatest         bool
+	LockFile          string
+	Keys              []string // paths/URLs to (or inline) trusted OpenPGP public keys
+	Signed            bool     // require signatures for primary index and extensions referenced by URLs
+	ParallelDownloads int      // how many plugins may be downloaded at the same time (0 - no limit)
+}
+
+func (c IndexConfig) signaturePolicy(cacheDir string, refresh time.Duration) (*index.SignaturePolicy, error) {
+	if len(c.Keys) == 0 {
+		if c.Signed {
+			return nil, fmt.Errorf("signatures are required but no trusted keys are configured")
+		}
+		return nil, nil
+	}
+
+	keyRing, err := loadKeyRing(c.Keys, filepath.Join(cacheDir, "keys"), refresh)
+	if err != nil {
+		return nil, err
+	}
+	return &index.SignaturePolicy{KeyRing: keyRing, Required: c.Signed}, nil
+}
+
+// loadIndex prints a summary line for every step so it should be called while printing the header
+func loadIndex(
+	config IndexConfig, cacheDir string, refresh time.Duration, applyLock bool,
+) (*index.LoadingIndex, *index.Lock, error) {
+	// Primary Index
+	fmt.Printf("- Primary Index: ")
+	signatures, err := config.signaturePolicy(cacheDir, refresh)
+	if err != nil {
+		return nil, nil, err
+	}
+	var loadingIndex *index.LoadingIndex
+	if len(config.PrimaryLayers) > 0 {
+		loadingIndex, err = index.MergeIndices(config.PrimaryLayers, config.Merge, cacheDir, refresh, signatures)
+		if err != nil {
+			return nil, nil, err
+		}
+		fmt.Printf("%d merged (later ones override %s)\n", len(loadingIndex.Layers), config.Merge)
+		for _, layer := range loadingIndex.Layers {
+			fmt.Printf("  * %s\n", describePrimaryIndex(layer))
+		}
+		for _, provenance := range describeProvenance(loadingIndex) {
+			fmt.Printf("  * %s\n", provenance)
+		}
+	} else {
+		loadingIndex, err = index.DiscoverIndex(config.PrimaryCandidates, cacheDir, refresh, signatures)
+		if err != nil {
+			return nil, nil, err
+		}
+		fmt.Println(describePrimaryIndex(loadingIndex))
+	}
+
+	// Index Extensions
+	fmt.Printf("- Index Extensions: ")
+	loadedExtensions, failedExtensions, err := loadExtensions(loadingIndex, config.Extensions)
+	if err != nil {
+		return nil, nil, err
+	}
+	var extensionsStats []string
+	for _, ext := range config.Extensions {
+		countLoaded := loadedExtensions[ext]
+		countFailed := failedExtensions[ext]
+		extensionsStats = append(
+			extensionsStats,
+			fmt.Sprintf("%s (%d/%d)", ext, countLoaded, countLoaded+countFailed),
+		)
+	}
+	fmt.Printf("%s\n", strings.Join(extensionsStats, ", "))
+	sort.Strings(loadingIndex.Warnings)
+	for _, warning := range loadingIndex.Warnings {
+		fmt.Printf("  * Warning: %s\n", warning)
+	}
+
+	// Plugin Pins
+	if len(config.Pins) > 0 {
+		fmt.Printf("- Plugin Pins: ")
+		pins, err := index.ParsePins(config.Pins)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot parse plugin pins: %s", err)
+		}
+		pinnedVersions, err := loadingIndex.ApplyPins(pins, config.PinLatest)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot satisfy plugin pins: %s", err)
+		}
+		var pinsStats []string
+		for idx, pin := range pins {
+			var versions []string
+			for _, v := range pinnedVersions[idx] {
+				versions = append(versions, v.String())
+			}
+			pinsStats = append(
+				pinsStats,
+				fmt.Sprintf("%s '%s' (%s)", pin.Key(), pin.Constraints, strings.Join(versions, ", ")),
+			)
+		}
+		fmt.Printf("%s\n", strings.Join(pinsStats, ", "))
+	}
+
+	// Lock File
+	fmt.Printf("- Lock File: ")
+	lock, err := index.LoadLock(config.LockFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	lockStats := "not present yet"
+	if !lock.IsEmpty() {
+		lockStats = fmt.Sprintf("%d plugins locked", len(lock.Keys()))
+		if applyLock {
+			err = loadingIndex.ApplyLock(lock, runtimePlatform())
+			if err != nil {
+				return nil, nil, err
+			}
+		} else {
+			lockStats += ", ignored"
+		}
+	}
+	fmt.Printf("%s (%s)\n", utils.PathSimplify(config.LockFile), lockStats)
+
+	return loadingIndex, lock, nil
+}
+
+func describePrimaryIndex(loadingIndex *index.LoadingIndex) string {
+	var indexStats []string
+	for kind, nameToPlugins := range loadingIndex.KindToNameToPlugins {
+		indexStats = append(indexStats, fmt.Sprintf("%ss: %d", kind, len(nameToPlugins)))
+	}
+	sort.Strings(indexStats)
+	if len(loadingIndex.NameToTools) > 0 {
+		indexStats = append(indexStats, fmt.Sprintf("%s: %d", index.SectionTools, len(loadingIndex.NameToTools)))
+	}
+	if len(loadingIndex.Included) > 0 {
+		indexStats = append(indexStats, fmt.Sprintf("includes: %d", len(loadingIndex.Included)))
+	}
+	if loadingIndex.Signer != "" {
+		indexStats = append(indexStats, fmt.Sprintf("signed by %s", loadingIndex.Signer))
+	}
+	return fmt.Sprintf(
+		"%s as of %s (%s)",
+		utils.UrlRedact(loadingIndex.Location),
+		loadingIndex.Timestamp.Format(time.RFC3339),
+		strings.Join(indexStats, ", "),
+	)
+}
+
+// describeProvenance tells which of merged primary indices every plugin (and tool) came from - except for ones that
+// entirely came from the first of them as that's the base everything else is merged over
+func describeProvenance(loadingIndex *index.LoadingIndex) []string {
+	base := loadingIndex.Layers[0].Location
+
+	describe := func(title string, plugins []*index.Plugin) string {
+		var sources []string
+		sourceToVersions := make(map[string]index.Versions)
+		seen := make(map[string]bool)
+		for _, p := range plugins {
+			if _, ok := sourceToVersions[p.Source]; !ok {
+				sources = append(sources, p.Source)
+			}
+			if !seen[p.Source+p.Version] {
+				seen[p.Source+p.Version] = true
+				sourceToVersions[p.Source] = append(sourceToVersions[p.Source], p.SemVer)
+			}
+		}
+		if len(sources) == 0 || len(sources) == 1 && sources[0] == base {
+			return ""
+		}
+		var provenance []string
+		for _, layer := range loadingIndex.Layers {
+			versions, ok := sourceToVersions[layer.Location]
+			if !ok {
+				continue
+			}
+			sort.Sort(versions)
+			var versionStrings []string
+			for _, v := range versions {
+				versionStrings = append(versionStrings, v.String())
+			}
+			provenance = append(provenance, fmt.Sprintf(
+				"%s (%s)", utils.UrlRedact(layer.Location), strings.Join(versionStrings, ", "),
+			))
+		}
+		return fmt.Sprintf("%s from %s", title, strings.Join(provenance, ", "))
+	}
+
+	var result []string
+	for kind, nameToPlugins := range loadingIndex.KindToNameToPlugins {
+		for name, plugins := range nameToPlugins {
+			if line := describe(fmt.Sprintf("%s '%s'", kind, name), plugins); line != "" {
+				result = append(result, line)
+			}
+		}
+	}
+	for name, tools := range loadingIndex.NameToTools {
+		if line := describe(fmt.Sprintf("tool '%s'", name), tools); line != "" {
+			result = append(result, line)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// loadExtensions tolerates broken extensions but fails right away when one of them doesn't pass signature verification
+func loadExtensions(
+	loadingIndex *index.LoadingIndex, extensions []string,
+) (loaded map[string]uint64, failed map[string]uint64, err error) {
+	loaded = make(map[string]uint64)
+	failed = make(map[string]uint64)
+
+	for idx := len(extensions) - 1; idx >= 0; idx-- {
+		path := extensions[idx]
+		expandedPath, err := homedir.Expand(path)
+		if err != nil {
+			continue
+		}
+		matches, _ := ioutil.ReadDir(expandedPath)
+		for _, ext := range matches {
+			if ext.IsDir() { // TODO trace
+				failed[path] += 1
+				continue
+			}
+
+			errLoad := loadingIndex.LoadExtension(filepath.Join(expandedPath, ext.Name()))
+			if _, ok := errLoad.(*index.SignatureError); ok {
+				return nil, nil, errLoad
+			}
+			if errLoad != nil {
+				failed[path] += 1
+			} else {
+				loaded[path] += 1
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,129 @@
+package app
+
+import (
+	"fmt"
+	"github.com/paraterraform/para/app/index"
+	"github.com/paraterraform/para/utils"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// updateLock records plugins served during a successful run, it never fails the run but reports issues
+func updateLock(lock *index.Lock, served []*index.Plugin) {
+	changed := 0
+	for _, plugin := range served {
+		if lock.Record(plugin) {
+			changed += 1
+		}
+	}
+	if changed == 0 {
+		return
+	}
+
+	err := lock.Save()
+	if err != nil {
+		fmt.Printf("* Para was unable to update lock file at '%s': %s\n", utils.PathSimplify(lock.Path), err)
+		return
+	}
+	fmt.Printf("- Para recorded %d plugin(s) in lock file '%s'\n", changed, utils.PathSimplify(lock.Path))
+}
+
+// Lock verifies lock file against the index or, when update is set, re-resolves given (or all) locked plugins
+func Lock(indexConfig IndexConfig, customCachePath string, refresh time.Duration, update bool, keys []string) {
+	fmt.Printf("- Cache Dir: ")
+	cacheDir, err := discoverCacheDir(customCachePath)
+	if err != nil {
+		fmt.Printf(
+			"\n* Error: Para requires a writable cache dir for operation but failed discovering one: %s\n",
+			err,
+		)
+		os.Exit(1)
+	}
+	fmt.Println(utils.PathSimplify(cacheDir))
+
+	loadingIndex, lock, err := loadIndex(indexConfig, cacheDir, refresh, !update)
+	if err != nil {
+		fmt.Printf("\n* Error: %s\n", err)
+		os.Exit(1)
+	}
+
+	if !update {
+		if lock.IsEmpty() {
+			fmt.Println("- Nothing is locked yet: run Terraform via Para or use 'para lock --update <kind>.<name>'")
+		} else {
+			fmt.Println("- Lock file is consistent with the index")
+		}
+		return
+	}
+
+	if len(keys) == 0 {
+		keys = lock.Keys()
+	}
+	if len(keys) == 0 {
+		fmt.Println("- Nothing to update: no plugins locked yet and none given explicitly")
+		return
+	}
+
+	currentPlatform := runtime.GOOS + "_" + runtime.GOARCH
+	for _, key := range keys {
+		kind, name, err := index.ParsePluginKey(key)
+		if err != nil {
+			fmt.Printf("* Error: %s\n", err)
+			os.Exit(1)
+		}
+
+		versions := loadingIndex.ListVersions(kind, name)
+		resolved := versions.Latest()
+		if resolved == nil && len(versions) > 0 {
+			resolved = versions[len(versions)-1] // only pre-releases are available
+		}
+		if resolved == nil {
+			fmt.Printf("* Error: %s '%s' is not present in the index (or does not satisfy pins)\n", kind, name)
+			os.Exit(1)
+		}
+
+		// keep the set of platforms that were locked before and add the current one
+		platforms := map[string]bool{currentPlatform: true}
+		for _, p := range lock.Lookup(kind, name) {
+			platforms[p.Platform] = true
+		}
+
+		var resolvedPlugins []*index.Plugin
+		var resolvedPlatforms []string
+		for _, p := range loadingIndex.KindToNameToPlugins[kind][name] {
+			if p.Version == resolved.String() && platforms[p.Platform] {
+				resolvedPlugins = append(resolvedPlugins, p)
+				resolvedPlatforms = append(resolvedPlatforms, p.Platform)
+				delete(platforms, p.Platform)
+			}
+		}
+		var missingPlatforms []string
+		for platform := range platforms {
+			missingPlatforms = append(missingPlatforms, platform)
+		}
+		if len(resolvedPlugins) == 0 {
+			fmt.Printf(
+				"* Error: %s '%s' version '%s' is not available for any of: %s\n",
+				kind, name, resolved, strings.Join(missingPlatforms, ", "),
+			)
+			os.Exit(1)
+		}
+
+		lock.Replace(kind, name, resolvedPlugins)
+		sort.Strings(resolvedPlatforms)
+		fmt.Printf("- Locked %s '%s' at '%s' (%s)\n", kind, name, resolved, strings.Join(resolvedPlatforms, ", "))
+		if len(missingPlatforms) > 0 {
+			sort.Strings(missingPlatforms)
+			fmt.Printf("  * Warning: version '%s' is not available for %s\n", resolved, strings.Join(missingPlatforms, ", "))
+		}
+	}
+
+	err = lock.Save()
+	if err != nil {
+		fmt.Printf("* Error: cannot save lock file at '%s': %s\n", utils.PathSimplify(lock.Path), err)
+		os.Exit(1)
+	}
+}
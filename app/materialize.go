@@ -0,0 +1,218 @@
+package app
+
+import (
+	"bazil.org/fuse"
+	"fmt"
+	"github.com/paraterraform/para/app/index"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Plugin dir modes tell how plugins are provided to Terraform in the plugin dir
+const (
+	PluginDirModeFuse = "fuse" // a read-only file system that downloads plugins when Terraform opens them
+	PluginDirModeLink = "link" // symlinks to plugins downloaded to the cache dir beforehand
+	PluginDirModeCopy = "copy" // hardlinks to (or copies of, if the cache dir is on another file system) cached plugins
+)
+
+// materializedList lists everything put in the plugin dir in link and copy modes (one path per line) so that it can be
+// cleaned up even if Para didn't exit properly - it's kept next to the PID file
+const materializedList = "para.plugins"
+
+func checkPluginDirMode(mode string) error {
+	switch mode {
+	case "", PluginDirModeFuse, PluginDirModeLink, PluginDirModeCopy:
+		return nil
+	}
+	return fmt.Errorf(
+		"unknown plugin dir mode '%s': must be one of '%s', '%s' or '%s'",
+		mode, PluginDirModeFuse, PluginDirModeLink, PluginDirModeCopy,
+	)
+}
+
+// providePluginDir serves plugins from the runtime index in the plugin dir according to the mode - FUSE is preferred
+// unless the mode is given explicitly but pinned or locked plugins are linked if FUSE is unavailable (downloading all
+// of them upfront is only done when asked for explicitly). Returns the mode that was used and what must be called once
+// Terraform exits. It prints a summary line so it should be called while printing the header.
+func providePluginDir(
+	runtimeIndex *index.RuntimeIndex, pins map[string]string, lock *index.Lock, pluginDir, mode string,
+) (string, func(), error) {
+	fmt.Printf("- Plugins: ")
+	listPath := filepath.Join(filepath.Dir(pluginDir), materializedList)
+	removeMaterializedPlugins(listPath) // left behind if the previous instance of Para didn't exit properly
+
+	selected := selectPluginKeys(pins, lock)
+	if mode == "" || mode == PluginDirModeFuse {
+		ready, err := mountPluginsDir(runtimeIndex, pluginDir)
+		if err == nil {
+			<-ready
+			fmt.Println("served via FUSE and downloaded on demand")
+			return PluginDirModeFuse, func() { _ = fuse.Unmount(pluginDir) }, nil
+		}
+		if mode == PluginDirModeFuse {
+			return "", nil, fmt.Errorf("unable to mount plugin FS over '%s': %s", pluginDir, err)
+		}
+		if len(selected) == 0 {
+			return "", nil, fmt.Errorf(
+				"FUSE is unavailable (%s) and no plugins are pinned or locked - either pin plugins Terraform needs "+
+					"or use --mode=%s to download all %d plugin versions for %s upfront",
+				err, PluginDirModeLink, len(runtimeIndex.ListPluginsForPlatform(runtimePlatform())), runtimePlatform(),
+			)
+		}
+		fmt.Printf("FUSE is unavailable (%s), ", err)
+		mode = PluginDirModeLink
+	}
+
+	release := func() { removeMaterializedPlugins(listPath) }
+	summary, problems, err := materializePlugins(runtimeIndex, selected, pluginDir, listPath, mode)
+	if err != nil {
+		release()
+		return "", nil, err
+	}
+	fmt.Println(summary)
+	for _, problem := range problems {
+		fmt.Printf("  * %s\n", problem)
+	}
+	return mode, release, nil
+}
+
+// selectPluginKeys tells which plugins (by pin keys) must be put in the plugin dir - pinned and locked ones
+func selectPluginKeys(pins map[string]string, lock *index.Lock) map[string]bool {
+	selected := make(map[string]bool)
+	parsedPins, _ := index.ParsePins(pins) // already applied to the index successfully
+	for _, pin := range parsedPins {
+		selected[pin.Key()] = true
+	}
+	if lock != nil {
+		for _, key := range lock.Keys() {
+			selected[key] = true
+		}
+	}
+	return selected
+}
+
+// materializePlugins downloads plugins for the current platform (just selected ones unless none are) and puts them in
+// the plugin dir so that Terraform finds them there without FUSE. Plugins that fail to download are reported as
+// problems - Terraform fails on its own if it needs them. Files that already exist in the plugin dir are kept as is.
+func materializePlugins(
+	runtimeIndex *index.RuntimeIndex, selected map[string]bool, pluginDir, listPath, mode string,
+) (string, []string, error) {
+	platform := runtimePlatform()
+	var plugins []*index.Plugin
+	for _, filename := range runtimeIndex.ListPluginsForPlatform(platform) {
+		plugin := runtimeIndex.LookupPlugin(platform, filename)
+		if len(selected) == 0 || selected[index.Pin{Kind: plugin.Kind, Name: plugin.Name}.Key()] {
+			plugins = append(plugins, plugin)
+		}
+	}
+	sort.Slice(plugins, func(a, b int) bool {
+		return plugins[a].Filename() < plugins[b].Filename()
+	})
+
+	list, err := os.OpenFile(listPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return "", nil, err
+	}
+	defer func() { _ = list.Close() }()
+	platformDir := filepath.Join(pluginDir, platform)
+	if _, err := os.Stat(platformDir); os.IsNotExist(err) {
+		_, err = fmt.Fprintln(list, platformDir) // listed before it's created so that it's never left behind
+		if err == nil {
+			err = os.Mkdir(platformDir, 0755)
+		}
+		if err != nil {
+			return "", nil, err
+		}
+	}
+
+	var placed, downloaded, kept int
+	var problems []string
+	for _, result := range prefetchPlugins(runtimeIndex, plugins, false) {
+		if result.err != nil {
+			problems = append(problems, result.describe())
+			continue
+		}
+		if !result.cached {
+			downloaded += 1
+		}
+		target := filepath.Join(platformDir, result.plugin.Filename())
+		if _, err := os.Lstat(target); err == nil {
+			kept += 1
+			continue
+		}
+		_, err = fmt.Fprintln(list, target)
+		if err == nil {
+			err = placePlugin(result.path, target, mode)
+		}
+		if err != nil {
+			return "", nil, fmt.Errorf("unable to put '%s' in the plugin dir: %s", result.plugin.Filename(), err)
+		}
+		placed += 1
+	}
+
+	verb := "linked"
+	if mode == PluginDirModeCopy {
+		verb = "copied"
+	}
+	summary := fmt.Sprintf("%d %s from the cache dir (%d downloaded", placed, verb, downloaded)
+	if kept > 0 {
+		summary += fmt.Sprintf(", %d already in the plugin dir", kept)
+	}
+	if len(problems) > 0 {
+		summary += fmt.Sprintf(", %d failed", len(problems))
+	}
+	return summary + ")", problems, nil
+}
+
+// placePlugin makes the cached plugin available at the target path - cached plugins can be replaced but never changed
+// in place so hardlinks are as good as copies
+func placePlugin(cachedPath, target, mode string) error {
+	source, err := filepath.Abs(cachedPath)
+	if err != nil {
+		return err
+	}
+	if mode == PluginDirModeLink {
+		return os.Symlink(source, target)
+	}
+	if os.Link(source, target) == nil {
+		return nil
+	}
+
+	reader, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = reader.Close() }()
+	writer, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0755)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(writer, reader)
+	errClose := writer.Close()
+	if err == nil {
+		err = errClose
+	}
+	if err != nil {
+		_ = os.Remove(target)
+	}
+	return err
+}
+
+// removeMaterializedPlugins removes everything listed (in reverse order so that dirs are empty by then) and the list
+func removeMaterializedPlugins(listPath string) {
+	raw, err := ioutil.ReadFile(listPath)
+	if err != nil {
+		return
+	}
+	paths := strings.Split(strings.TrimSpace(string(raw)), "\n")
+	for idx := len(paths) - 1; idx >= 0; idx-- {
+		if paths[idx] != "" {
+			_ = os.Remove(paths[idx]) // dirs are only removed if they are empty
+		}
+	}
+	_ = os.Remove(listPath)
+}
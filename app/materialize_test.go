@@ -0,0 +1,108 @@
+package app
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"github.com/paraterraform/para/app/index"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestSelectPluginKeys(t *testing.T) {
+	lock := index.NewLock(filepath.Join(t.TempDir(), "para.lock.yaml"))
+	lock.Record(&index.Plugin{
+		Kind: "provider", Name: "bar", Version: "v0.1.0", SemVer: index.MustParseVersion("v0.1.0"),
+		Platform: "linux_amd64", Size: 1, Digest: "sha256:00",
+	})
+	pins := map[string]string{"provider.foo": "~> 1.0"}
+
+	cases := []struct {
+		name     string
+		pins     map[string]string
+		lock     *index.Lock
+		expected map[string]bool
+	}{
+		{"none", nil, nil, map[string]bool{}},
+		{"empty lock", nil, index.NewLock("para.lock.yaml"), map[string]bool{}},
+		{"pinned", pins, nil, map[string]bool{"provider.foo": true}},
+		{"locked", nil, lock, map[string]bool{"provider.bar": true}},
+		{"both", pins, lock, map[string]bool{"provider.foo": true, "provider.bar": true}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if actual := selectPluginKeys(c.pins, c.lock); !reflect.DeepEqual(actual, c.expected) {
+				t.Errorf("expected %v, got %v", c.expected, actual)
+			}
+		})
+	}
+}
+
+func TestMaterializePlugins(t *testing.T) {
+	dir := t.TempDir()
+	document := "provider:\n"
+	for _, name := range []string{"foo", "bar"} {
+		content := []byte("#!/bin/sh\necho " + name + "\n")
+		source := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(source, content, 0755); err != nil {
+			t.Fatal(err)
+		}
+		document += fmt.Sprintf(
+			"  %s:\n    v1.0.0:\n      %s: {url: \"%s\", size: %d, digest: \"sha256:%x\"}\n",
+			name, runtimePlatform(), source, len(content), sha256.Sum256(content),
+		)
+	}
+	indexPath := filepath.Join(dir, "para.idx.yaml")
+	if err := ioutil.WriteFile(indexPath, []byte(document), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name     string
+		selected map[string]bool
+		expected []string
+	}{
+		{"all", nil, []string{"terraform-provider-bar_v1.0.0", "terraform-provider-foo_v1.0.0"}},
+		{"selected", map[string]bool{"provider.foo": true}, []string{"terraform-provider-foo_v1.0.0"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			loadingIndex, err := index.DiscoverIndex([]string{indexPath}, t.TempDir(), 0, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pluginDir := filepath.Join(t.TempDir(), "plugins")
+			if err := os.Mkdir(pluginDir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			listPath := filepath.Join(filepath.Dir(pluginDir), materializedList)
+
+			_, problems, err := materializePlugins(
+				loadingIndex.BuildRuntimeIndex(0), c.selected, pluginDir, listPath, PluginDirModeLink,
+			)
+			if err != nil || len(problems) > 0 {
+				t.Fatalf("expected plugins to be linked, got %v (%v)", err, problems)
+			}
+			children, err := ioutil.ReadDir(filepath.Join(pluginDir, runtimePlatform()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var actual []string
+			for _, child := range children {
+				actual = append(actual, child.Name())
+			}
+			sort.Strings(actual)
+			if !reflect.DeepEqual(actual, c.expected) {
+				t.Errorf("expected %v, got %v", c.expected, actual)
+			}
+
+			removeMaterializedPlugins(listPath)
+			if _, err := os.Stat(filepath.Join(pluginDir, runtimePlatform())); !os.IsNotExist(err) {
+				t.Errorf("expected linked plugins to be removed, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package app
+
+import (
+	"fmt"
+	"github.com/paraterraform/para/app/index"
+	"github.com/paraterraform/para/utils"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// prefetchResult tells what happened to a plugin when it was prefetched
+type prefetchResult struct {
+	plugin    *index.Plugin
+	path      string
+	cached    bool  // it was in the cache dir already and intact
+	corrupted error // why the cached copy was downloaded once again
+	err       error
+}
+
+func (r *prefetchResult) describe() string {
+	title := fmt.Sprintf(
+		"%s '%s' version '%s' for '%s'", r.plugin.Kind, r.plugin.Name, r.plugin.Version, r.plugin.Platform,
+	)
+	switch {
+	case r.err != nil:
+		return fmt.Sprintf("Error: %s: %s", title, r.err)
+	case r.corrupted != nil:
+		return fmt.Sprintf("%s: downloaded once again, cached copy was corrupted (%s)", title, r.corrupted)
+	case r.cached:
+		return fmt.Sprintf("%s: already cached", title)
+	}
+	return fmt.Sprintf("%s: downloaded (%s)", title, utils.SizeFormat(int64(r.plugin.Size)))
+}
+
+// Prefetch downloads plugins selected by the index (as narrowed down by pins and the lock file) for given platforms (or
+// the current one) to the cache dir so that Terraform doesn't have to wait for them later
+func Prefetch(
+	indexConfig IndexConfig, customCachePath string, refresh time.Duration, platforms []string, pinnedOnly bool,
+) {
+	cacheDir := openCacheDir(customCachePath)
+	loadingIndex, _, err := loadIndex(indexConfig, cacheDir, refresh, true)
+	if err != nil {
+		fmt.Printf("\n* Error: %s\n", err)
+		os.Exit(1)
+	}
+
+	if len(platforms) == 0 {
+		platforms = []string{runtimePlatform()}
+	}
+	plugins, err := selectPlugins(loadingIndex, indexConfig.Pins, pinnedOnly, platforms)
+	if err != nil {
+		fmt.Printf("* Error: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("- Plugins: %d for %s\n", len(plugins), strings.Join(listPlatforms(plugins), ", "))
+
+	var downloaded, cached, failed int
+	var size int64
+	results := prefetchPlugins(loadingIndex.BuildRuntimeIndex(indexConfig.ParallelDownloads), plugins, true)
+	for _, result := range results {
+		switch {
+		case result.err != nil:
+			failed += 1
+		case result.cached:
+			cached += 1
+		default:
+			downloaded += 1
+			size += int64(result.plugin.Size)
+		}
+	}
+	fmt.Printf(
+		"- Prefetched: %d downloaded (%s), %d already cached, %d failed\n",
+		downloaded, utils.SizeFormat(size), cached, failed,
+	)
+	for _, result := range results {
+		fmt.Printf("  * %s\n", result.describe())
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// selectPlugins picks plugins from the index (all of them or just pinned ones, for all or just given platforms) in a
+// stable order
+func selectPlugins(
+	loadingIndex *index.LoadingIndex, rawPins map[string]string, pinnedOnly bool, platforms []string,
+) ([]*index.Plugin, error) {
+	pinned := make(map[string]bool)
+	if pinnedOnly {
+		pins, err := index.ParsePins(rawPins)
+		if err != nil {
+			return nil, err
+		}
+		if len(pins) == 0 {
+			return nil, fmt.Errorf("only pinned plugins are requested but no plugins are pinned")
+		}
+		for _, pin := range pins {
+			pinned[pin.Key()] = true
+		}
+	}
+	wantedPlatforms := make(map[string]bool)
+	for _, platform := range platforms {
+		wantedPlatforms[platform] = true
+	}
+
+	var result []*index.Plugin
+	for kind, nameToPlugins := range loadingIndex.KindToNameToPlugins {
+		for name, plugins := range nameToPlugins {
+			if pinnedOnly && !pinned[index.Pin{Kind: kind, Name: name}.Key()] {
+				continue
+			}
+			for _, plugin := range plugins {
+				if len(wantedPlatforms) == 0 || wantedPlatforms[plugin.Platform] {
+					result = append(result, plugin)
+				}
+			}
+		}
+	}
+	sort.Slice(result, func(a, b int) bool {
+		if result[a].Kind != result[b].Kind {
+			return result[a].Kind < result[b].Kind
+		}
+		if result[a].Name != result[b].Name {
+			return result[a].Name < result[b].Name
+		}
+		if result[a].Version != result[b].Version {
+			return result[a].SemVer.LessThan(result[b].SemVer)
+		}
+		return result[a].Platform < result[b].Platform
+	})
+	if len(result) == 0 {
+		return nil, fmt.Errorf("the index has no plugins for %s", strings.Join(platforms, ", "))
+	}
+	return result, nil
+}
+
+func listPlatforms(plugins []*index.Plugin) []string {
+	seen := make(map[string]bool)
+	var platforms []string
+	for _, plugin := range plugins {
+		if !seen[plugin.Platform] {
+			seen[plugin.Platform] = true
+			platforms = append(platforms, plugin.Platform)
+		}
+	}
+	sort.Strings(platforms)
+	return platforms
+}
+
+// prefetchPlugins downloads plugins in parallel (as much as the runtime index allows) while cached ones are optionally
+// re-verified and downloaded once again if they are corrupted
+func prefetchPlugins(runtimeIndex *index.RuntimeIndex, plugins []*index.Plugin, reverify bool) []*prefetchResult {
+	results := make([]*prefetchResult, len(plugins))
+	var wg sync.WaitGroup
+	for idx, plugin := range plugins {
+		wg.Add(1)
+		go func(idx int, plugin *index.Plugin) {
+			defer wg.Done()
+			results[idx] = prefetchPlugin(runtimeIndex, plugin, reverify)
+		}(idx, plugin)
+	}
+	wg.Wait()
+	return results
+}
+
+func prefetchPlugin(runtimeIndex *index.RuntimeIndex, plugin *index.Plugin, reverify bool) *prefetchResult {
+	result := &prefetchResult{plugin: plugin, cached: runtimeIndex.IsPluginCached(plugin)}
+	result.path, result.err = runtimeIndex.CachePlugin(plugin)
+	if result.err != nil || !result.cached || !reverify {
+		return result
+	}
+
+	result.corrupted = verifyCachedPlugin(result.path, int64(plugin.Size), plugin)
+	if result.corrupted == nil {
+		return result
+	}
+	result.cached = false
+	result.err = removeCachedPlugin(result.path)
+	if result.err == nil {
+		_, result.err = runtimeIndex.CachePlugin(plugin)
+	}
+	return result
+}
+
+// removeCachedPlugin waits until nobody else downloads the plugin and removes it
+func removeCachedPlugin(path string) error {
+	lock, err := utils.LockCacheEntry(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = lock.Unlock() }()
+	return os.Remove(path)
+}
@@ -1,73 +1,341 @@
 package app
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/paraterraform/para/app/index"
 	"github.com/paraterraform/para/utils"
+	"io/ioutil"
 	"path/filepath"
 	"regexp"
-	"runtime"
+	"sort"
+	"strings"
 	"time"
 )
 
 const (
 	terraformExec     = "terraform"
 	terraformReleases = "https://releases.hashicorp.com/terraform/"
+	terraformIndex    = "index.json" // both for all releases and for each of them
+
+	terraformVersionFile  = ".terraform-version"
+	terraformVersionFlag  = "--terraform"
+	terraformPrerelease   = "--terraform-prerelease"
+	terraformRequiredAttr = "required_version"
+
+	// HashiCorp signs checksums of its releases with the embedded key - it's pinned by its fingerprint as well so that
+	// it's never replaced by accident (not even by the published one that's fetched once the embedded one expires)
+	hashicorpKeyFingerprint = "C874011F0AB405110D02105534365D9472D7468F"
+	hashicorpKeyUrl         = "https://www.hashicorp.com/.well-known/pgp-key.txt"
 )
 
-var terraformVersionRe = *regexp.MustCompile(`href="/terraform/([\d\\.]+?)/"`)
+// required_version is only allowed within terraform {} block so there is no need to parse files completely
+var terraformRequiredVersionRes = map[string]*regexp.Regexp{
+	"*.tf":      regexp.MustCompile(`\brequired_version\s*=\s*"([^"]*)"`),
+	"*.tf.json": regexp.MustCompile(`"required_version"\s*:\s*"([^"]*)"`),
+}
+
+// terraformRequirement finds out which Terraform version is required: from flag/config, from .terraform-version (in
+// the working dir or any of its parents) or from required_version in *.tf files in the working dir - in that order.
+// Returns nil if there is no requirement (and pre-releases are not asked for).
+func terraformRequirement(config ToolsConfig, workDir string) (*versionRequirement, error) {
+	requirement, err := findTerraformRequirement(config, workDir)
+	if err != nil {
+		return nil, err
+	}
+	if config.TerraformPrerelease {
+		if requirement == nil {
+			requirement = &versionRequirement{Source: terraformPrerelease}
+		}
+		requirement.Prerelease = true
+	}
+	return requirement, nil
+}
+
+func findTerraformRequirement(config ToolsConfig, workDir string) (*versionRequirement, error) {
+	if config.TerraformVersion != "" {
+		return parseVersionRequirement(config.TerraformVersion, terraformVersionFlag)
+	}
+
+	requirement, err := findVersionFile(workDir, terraformVersionFile)
+	if requirement != nil || err != nil {
+		return requirement, err
+	}
+
+	fileToRe := make(map[string]*regexp.Regexp)
+	var files []string
+	for pattern, re := range terraformRequiredVersionRes {
+		matches, _ := filepath.Glob(filepath.Join(workDir, pattern))
+		for _, file := range matches {
+			fileToRe[file] = re
+			files = append(files, file)
+		}
+	}
+	sort.Strings(files)
+
+	var constraints []string
+	var sources []string
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasSuffix(file, ".tf") {
+			content = stripHclComments(content)
+		}
+		matches := fileToRe[file].FindAllStringSubmatch(string(content), -1)
+		for _, match := range matches {
+			constraints = append(constraints, match[1])
+		}
+		if len(matches) > 0 {
+			sources = append(sources, filepath.Base(file))
+		}
+	}
+	if len(constraints) == 0 {
+		return nil, nil
+	}
+	// Terraform requires all of them to be satisfied
+	return parseVersionRequirement(
+		strings.Join(constraints, ", "),
+		fmt.Sprintf("%s in %s", terraformRequiredAttr, strings.Join(sources, ", ")),
+	)
+}
+
+// terraformReleaseIndex is the structured listing published at releases.hashicorp.com/terraform/index.json
+type terraformReleaseIndex struct {
+	Versions map[string]*terraformRelease `json:"versions"`
+}
+
+type terraformRelease struct {
+	Version          string            `json:"version"`
+	Shasums          string            `json:"shasums"`
+	ShasumsSignature string            `json:"shasums_signature"`
+	Builds           []*terraformBuild `json:"builds"`
+}
+
+type terraformBuild struct {
+	Os       string `json:"os"`
+	Arch     string `json:"arch"`
+	Filename string `json:"filename"`
+}
+
+// findBuild picks the build for the platform (in the same format as used in the index)
+func (r *terraformRelease) findBuild(platform string) (*terraformBuild, error) {
+	for _, build := range r.Builds {
+		if build.Os+"_"+build.Arch == platform {
+			return build, nil
+		}
+	}
+	return nil, fmt.Errorf("there is no build of Terraform %s for %s", r.Version, platform)
+}
+
+// fetchTerraformReleases fetches the listing of all releases
+func fetchTerraformReleases(
+	releasesUrl, cacheDir string, refresh time.Duration,
+) (map[string]*terraformRelease, error) {
+	url := utils.UrlJoin(releasesUrl, terraformIndex)
+	raw, _, err := utils.DownloadableFile{Url: url}.ReadAllWithCache(filepath.Join(cacheDir, "versions"), refresh)
+	if err != nil {
+		return nil, err
+	}
+	var releaseIndex terraformReleaseIndex
+	err = json.Unmarshal(raw, &releaseIndex)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode releases at '%s': %s", url, err)
+	}
+	return releaseIndex.Versions, nil
+}
+
+// fetchTerraformRelease fetches details of a single release so that there is no need to fetch the whole listing
+func fetchTerraformRelease(
+	releasesUrl, version, cacheDir string, refresh time.Duration,
+) (*terraformRelease, error) {
+	url := utils.UrlJoin(releasesUrl, version, terraformIndex)
+	raw, _, err := utils.DownloadableFile{Url: url}.ReadAllWithCache(filepath.Join(cacheDir, "versions"), refresh)
+	if err != nil {
+		return nil, err
+	}
+	var release terraformRelease
+	err = json.Unmarshal(raw, &release)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode release at '%s': %s", url, err)
+	}
+	return &release, nil
+}
+
+// terraformTool downloads Terraform releases and verifies them against signed checksums
+type terraformTool struct {
+	config   ToolsConfig
+	cacheDir string
+	refresh  time.Duration
+	releases map[string]*terraformRelease // all releases once they were listed
+}
+
+func (t *terraformTool) name() string {
+	return terraformExec
+}
+
+func (t *terraformTool) toolCacheDir() string {
+	return filepath.Join(t.cacheDir, terraformExec)
+}
+
+func (t *terraformTool) versionName(version *index.Version) string {
+	return version.Core()
+}
+
+func (t *terraformTool) listVersions(bool) (index.Versions, error) {
+	var err error
+	t.releases, err = fetchTerraformReleases(t.config.terraformReleases(), t.toolCacheDir(), t.refresh)
+	if err != nil {
+		return nil, err
+	}
+	var knownVersions index.Versions
+	for raw := range t.releases {
+		version, err := index.ParseVersion(raw)
+		if err != nil {
+			continue // not a release we'd know how to deal with
+		}
+		knownVersions = append(knownVersions, version)
+	}
+	return knownVersions, nil
+}
+
+func (t *terraformTool) findDownload(version *index.Version, platform string) (*toolDownload, error) {
+	config := t.config
+	versionToDownload := t.versionName(version)
+
+	release, ok := t.releases[versionToDownload]
+	if !ok {
+		var err error
+		release, err = fetchTerraformRelease(config.terraformReleases(), versionToDownload, t.toolCacheDir(), t.refresh)
+		if err != nil {
+			return nil, err
+		}
+	}
+	build, err := release.findBuild(platform)
+	if err != nil {
+		return nil, err
+	}
 
-func downloadTerraform(version, cacheDir string, refresh time.Duration) (string, error) {
-	terraformCacheDir := filepath.Join(cacheDir, terraformExec)
+	expectedFileName := build.Filename
+	// build URLs from the listing point to upstream so they are not used to let mirrors work
+	urlVersionPrefix := utils.UrlJoin(config.terraformReleases(), versionToDownload)
+	urlVersionChecksums := utils.UrlJoin(urlVersionPrefix, release.Shasums)
+	urlVersionSignature := urlVersionChecksums + ".sig"
+	if release.ShasumsSignature != "" {
+		urlVersionSignature = utils.UrlJoin(urlVersionPrefix, release.ShasumsSignature)
+	}
+	urlVersionBinary := utils.UrlJoin(config.terraformDownloads(), versionToDownload, build.Filename)
+	checksumsCacheDir := filepath.Join(t.toolCacheDir(), "checksums")
 
-	var versionToDownload string
+	var problems []error
+	var signer string
+	var sha256 string
 
-	if version != "" {
-		versionToDownload = version
+	checksums, _, err := utils.DownloadableFile{Url: urlVersionChecksums}.ReadAllWithCache(checksumsCacheDir, t.refresh)
+	if err != nil {
+		problems = append(problems, &VerificationError{Url: urlVersionChecksums, Artifact: expectedFileName, Reason: err})
 	} else {
-		versionsHtmlBytes, _, err := utils.DownloadableFile{Url: terraformReleases}.ReadAllWithCache(
-			filepath.Join(terraformCacheDir, "versions"), refresh,
-		)
+		keyRing, err := terraformKeyRing(config, t.cacheDir, t.refresh)
 		if err != nil {
-			return "", err
+			problems = append(problems, &VerificationError{
+				Url:      urlVersionSignature,
+				Artifact: urlVersionChecksums,
+				Reason:   fmt.Errorf("cannot load keys trusted to sign Terraform releases: %s", err),
+			})
+		} else {
+			signature, _, err := utils.DownloadableFile{Url: urlVersionSignature}.ReadAllWithCache(
+				checksumsCacheDir, t.refresh,
+			)
+			if err != nil {
+				problems = append(problems, &VerificationError{
+					Url: urlVersionSignature, Artifact: urlVersionChecksums, Reason: err,
+				})
+			} else {
+				var refreshKeyRing func() (*utils.PgpKeyRing, error)
+				if len(config.TerraformKeys) == 0 {
+					refreshKeyRing = func() (*utils.PgpKeyRing, error) {
+						return hashicorpPublishedKeyRing(hashicorpKeyUrl, t.cacheDir, t.refresh)
+					}
+				}
+				signer, err = verifyTerraformSignature(keyRing, refreshKeyRing, checksums, signature)
+				switch {
+				case errors.Is(err, utils.ErrPgpKeyExpired):
+					// an expired key doesn't mean that checksums were tampered with so it's up to --insecure
+					problems = append(problems, &VerificationError{
+						Url:      urlVersionSignature,
+						Artifact: urlVersionChecksums,
+						Reason: fmt.Errorf(
+							"the key trusted to sign Terraform releases has expired (list the current one with "+
+								"'terraform-keys' in config): %s", err,
+						),
+					})
+				case err != nil:
+					// an invalid signature is never tolerated
+					return nil, fmt.Errorf("signature of '%s' is invalid: %s", urlVersionChecksums, err)
+				}
+			}
 		}
-		var knownVersions []string
-		for _, match := range terraformVersionRe.FindAllStringSubmatch(string(versionsHtmlBytes), -1) {
-			knownVersions = append(knownVersions, match[1])
+
+		sha256, err = findChecksumForFile("sha256:", urlVersionChecksums, checksums, expectedFileName)
+		if err != nil {
+			problems = append(problems, err)
 		}
-		versionToDownload = knownVersions[0] // keep it sample for now, assume list is sorted
 	}
 
-	pathToVersionDir := filepath.Join(
-		terraformCacheDir,
-		versionToDownload,
-		fmt.Sprintf("%s_%s", runtime.GOOS, runtime.GOARCH),
-	)
-	pathToExecutable := filepath.Join(pathToVersionDir, terraformExec)
-	if utils.PathExists(pathToExecutable) {
-		// already downloaded & cached
-		// given that checksums published for archives we will check them when fetching binaries and before unpacking
-		return pathToVersionDir, nil
-	}
-
-	// windows binary has .exe suffix but there is no FUSE on windows so there is no para on windows ¯\_(ツ)_/¯
-	expectedFileName := terraformExec + "_" + versionToDownload + "_" + runtime.GOOS + "_" + runtime.GOARCH + ".zip"
-	urlVersionPrefix := utils.UrlJoin(terraformReleases, versionToDownload)
-	urlVersionChecksums := utils.UrlJoin(urlVersionPrefix, terraformExec+"_"+versionToDownload+"_SHA256SUMS")
-	urlVersionBinary := utils.UrlJoin(urlVersionPrefix, expectedFileName)
-
-	sha256 := findChecksumForFile("sha256:",
-		urlVersionChecksums, expectedFileName,
-		filepath.Join(terraformCacheDir, "checksums"), refresh,
-	)
+	return &toolDownload{
+		File: utils.DownloadableFile{
+			Url:            urlVersionBinary,
+			Digest:         sha256,
+			ExtractPattern: "terraform*",
+		},
+		Signer:   signer,
+		Problems: problems,
+	}, nil
+}
 
-	err := utils.DownloadableFile{
-		Url:            urlVersionBinary,
-		Digest:         sha256,
-		ExtractPattern: "terraform*",
-	}.SaveTo(pathToExecutable)
+// terraformKeyRing uses keys from config if any or falls back to the embedded HashiCorp key
+func terraformKeyRing(config ToolsConfig, cacheDir string, refresh time.Duration) (*utils.PgpKeyRing, error) {
+	if len(config.TerraformKeys) > 0 {
+		return loadKeyRing(config.TerraformKeys, filepath.Join(cacheDir, "keys"), refresh)
+	}
+
+	keyRing := &utils.PgpKeyRing{}
+	err := keyRing.AddPinned([]byte(hashicorpPublicKey), hashicorpKeyFingerprint)
 	if err != nil {
-		return "", err
+		return nil, fmt.Errorf("embedded HashiCorp key: %s", err)
 	}
+	return keyRing, nil
+}
 
-	return pathToVersionDir, nil
+// hashicorpPublishedKeyRing reads the HashiCorp key as currently published (HashiCorp extends its expiration from time
+// to time) - only the key with the same fingerprint as the embedded one is trusted
+func hashicorpPublishedKeyRing(url, cacheDir string, refresh time.Duration) (*utils.PgpKeyRing, error) {
+	raw, _, err := utils.DownloadableFile{Url: url}.ReadAllWithCache(filepath.Join(cacheDir, "keys"), refresh)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read HashiCorp key from '%s': %s", url, err)
+	}
+	keyRing := &utils.PgpKeyRing{}
+	err = keyRing.AddPinned(raw, hashicorpKeyFingerprint)
+	if err != nil {
+		return nil, fmt.Errorf("HashiCorp key from '%s': %s", url, err)
+	}
+	return keyRing, nil
+}
+
+// verifyTerraformSignature verifies a signature of Terraform checksums and returns its signer - if the key has expired
+// by the time of signing then the key ring is refreshed (unless there is no way to) and the signature is checked again
+func verifyTerraformSignature(
+	keyRing *utils.PgpKeyRing, refreshKeyRing func() (*utils.PgpKeyRing, error), checksums, signature []byte,
+) (string, error) {
+	signer, err := keyRing.VerifyDetached(checksums, signature)
+	if !errors.Is(err, utils.ErrPgpKeyExpired) || refreshKeyRing == nil {
+		return signer, err
+	}
+	refreshed, errRefresh := refreshKeyRing()
+	if errRefresh != nil {
+		return "", fmt.Errorf("%w (and the key cannot be refreshed: %s)", err, errRefresh)
+	}
+	return refreshed.VerifyDetached(checksums, signature)
 }
@@ -0,0 +1,103 @@
+package app
+
+import (
+	"errors"
+	"github.com/paraterraform/para/utils"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTerraformKeyRingEmbedded(t *testing.T) {
+	keyRing, err := terraformKeyRing(ToolsConfig{}, t.TempDir(), 0)
+	if err != nil {
+		t.Fatalf("embedded HashiCorp key should load: %s", err)
+	}
+	if keyRing.IsEmpty() {
+		t.Fatal("embedded HashiCorp key ring should not be empty")
+	}
+}
+
+// Test vectors are shared with utils - dave-extended.asc is dave.asc with its expiration extended to 2040 the way
+// HashiCorp extends expiration of its key
+func TestVerifyTerraformSignature(t *testing.T) {
+	read := func(name string) []byte {
+		raw, err := ioutil.ReadFile(filepath.Join("..", "utils", "testdata", "openpgp", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return raw
+	}
+	keyRing := func(name string) *utils.PgpKeyRing {
+		keyRing := &utils.PgpKeyRing{}
+		if err := keyRing.Add(read(name)); err != nil {
+			t.Fatal(err)
+		}
+		return keyRing
+	}
+	refreshTo := func(name string) func() (*utils.PgpKeyRing, error) {
+		return func() (*utils.PgpKeyRing, error) {
+			return keyRing(name), nil
+		}
+	}
+	message := read("message.txt")
+
+	for _, tc := range []struct {
+		name      string
+		keys      string
+		refresh   func() (*utils.PgpKeyRing, error)
+		data      []byte
+		signature string
+		expired   bool
+		err       string
+	}{
+		{"valid", "dave.asc", nil, message, "dave-before.sig", false, ""},
+		{"expired", "dave.asc", nil, message, "dave-after.sig", true, "after the key had expired"},
+		{"refreshed", "dave.asc", refreshTo("dave-extended.asc"), message, "dave-after.sig", false, ""},
+		{"still expired", "dave.asc", refreshTo("dave.asc"), message, "dave-after.sig", true, "after the key"},
+		{
+			"not refreshed", "dave.asc", func() (*utils.PgpKeyRing, error) {
+				return nil, errors.New("offline")
+			}, message, "dave-after.sig", true, "cannot be refreshed: offline",
+		},
+		{"invalid", "dave.asc", refreshTo("dave-extended.asc"), message[1:], "dave-before.sig", false, "does not match"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			signer, err := verifyTerraformSignature(keyRing(tc.keys), tc.refresh, tc.data, read(tc.signature))
+			if tc.err == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				if signer == "" {
+					t.Fatal("expected the signer to be reported")
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Fatalf("expected error containing '%s', got %v", tc.err, err)
+			}
+			if expired := errors.Is(err, utils.ErrPgpKeyExpired); expired != tc.expired {
+				t.Fatalf("expected the key to be reported as expired: %t, got %t", tc.expired, expired)
+			}
+		})
+	}
+}
+
+func TestHashicorpPublishedKeyRing(t *testing.T) {
+	dir := t.TempDir()
+	published := filepath.Join(dir, "hashicorp.asc")
+	if err := ioutil.WriteFile(published, []byte(hashicorpPublicKey), 0644); err != nil {
+		t.Fatal(err)
+	}
+	keyRing, err := hashicorpPublishedKeyRing(published, dir, 0)
+	if err != nil || keyRing.IsEmpty() {
+		t.Fatalf("expected the published key to be loaded, got %v", err)
+	}
+
+	other := filepath.Join("..", "utils", "testdata", "openpgp", "dave-extended.asc")
+	_, err = hashicorpPublishedKeyRing(other, dir, 0)
+	if err == nil || !strings.Contains(err.Error(), hashicorpKeyFingerprint) {
+		t.Fatalf("expected a key with another fingerprint to be rejected, got %v", err)
+	}
+}
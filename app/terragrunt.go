@@ -3,76 +3,203 @@ package app
 import (
 	"encoding/json"
 	"fmt"
+	"github.com/paraterraform/para/app/index"
 	"github.com/paraterraform/para/utils"
+	"io/ioutil"
 	"path/filepath"
-	"runtime"
+	"regexp"
 	"time"
 )
 
 const (
 	terragruntExec     = "terragrunt"
-	terragruntReleases = "https://api.github.com/repos/gruntwork-io/terragrunt/releases"
+	terragruntReleases = "https://api.github.com/repos/gruntwork-io/terragrunt/releases" // or a dir with release dirs
 	terragruntDownload = "https://github.com/gruntwork-io/terragrunt/releases/download"
+
+	terragruntVersionFile    = ".terragrunt-version"
+	terragruntVersionFlag    = "--terragrunt"
+	terragruntConfigFile     = "terragrunt.hcl"
+	terragruntConstraintAttr = "terragrunt_version_constraint"
+
+	terragruntReleasesPerPage = 100
+	terragruntReleasesMaxPage = 20 // just a safety net, there are way less releases than that
 )
 
-func downloadTerragrunt(version, cacheDir string, refresh time.Duration) (string, error) {
-	terragruntCacheDir := filepath.Join(cacheDir, terragruntExec)
+var terragruntConstraintRe = regexp.MustCompile(`\bterragrunt_version_constraint\s*=\s*"([^"]*)"`)
+
+type terragruntRelease struct {
+	TagName    string `json:"tag_name"`
+	Draft      bool   `json:"draft"`
+	Prerelease bool   `json:"prerelease"`
+}
 
-	var versionToDownload string
+// terragruntRequirement finds out which Terragrunt version is required: from flag/config, from .terragrunt-version or
+// from terragrunt_version_constraint in terragrunt.hcl (in the working dir or any of its parents) - in that order.
+// Returns nil if there is no requirement.
+func terragruntRequirement(config ToolsConfig, workDir string) (*versionRequirement, error) {
+	if config.TerragruntVersion != "" {
+		return parseVersionRequirement(config.TerragruntVersion, terragruntVersionFlag)
+	}
+
+	requirement, err := findVersionFile(workDir, terragruntVersionFile)
+	if requirement != nil || err != nil {
+		return requirement, err
+	}
 
-	var urlRelease string
-	if version != "" {
-		versionToDownload = "v" + version
+	// same as find_in_parent_folders() - the closest config that has the constraint wins
+	for dir := workDir; ; dir = filepath.Dir(dir) {
+		path := filepath.Join(dir, terragruntConfigFile)
+		if utils.PathExists(path) {
+			content, err := ioutil.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			match := terragruntConstraintRe.FindSubmatch(stripHclComments(content))
+			if match != nil {
+				return parseVersionRequirement(
+					string(match[1]),
+					fmt.Sprintf("%s in %s", terragruntConstraintAttr, utils.PathSimplify(path)),
+				)
+			}
+		}
+		if filepath.Dir(dir) == dir {
+			return nil, nil
+		}
+	}
+}
+
+// listTerragruntVersions lists all releases if needed or just the latest one otherwise
+func listTerragruntVersions(
+	releasesUrl, cacheDir string, refresh time.Duration, all bool,
+) (index.Versions, error) {
+	if !utils.UrlIsRemote(releasesUrl) {
+		return listTerragruntVersionsDir(releasesUrl)
+	}
+
+	versionsCacheDir := filepath.Join(cacheDir, "versions")
+
+	var urls []string
+	if all {
+		for page := 1; page <= terragruntReleasesMaxPage; page++ {
+			urls = append(urls, fmt.Sprintf("%s?per_page=%d&page=%d", releasesUrl, terragruntReleasesPerPage, page))
+		}
 	} else {
-		urlRelease = utils.UrlJoin(terragruntReleases, "latest")
-		releaseJsonBytes, _, err := utils.DownloadableFile{
-			Url: urlRelease,
-		}.ReadAllWithCache(filepath.Join(terragruntCacheDir, "versions"), refresh)
+		urls = append(urls, utils.UrlJoin(releasesUrl, "latest"))
+	}
+
+	var knownVersions index.Versions
+	for _, url := range urls {
+		releasesJsonBytes, _, err := utils.DownloadableFile{Url: url}.ReadAllWithCache(versionsCacheDir, refresh)
+		if err != nil {
+			return nil, err
+		}
+
+		var releases []terragruntRelease
+		if all {
+			err = json.Unmarshal(releasesJsonBytes, &releases)
+		} else {
+			releases = make([]terragruntRelease, 1)
+			err = json.Unmarshal(releasesJsonBytes, &releases[0])
+		}
 		if err != nil {
-			return "", nil
+			return nil, fmt.Errorf("cannot decode releases at '%s': %s", url, err)
 		}
 
-		var releaseJson map[string]interface{}
-		if err := json.Unmarshal(releaseJsonBytes, &releaseJson); err != nil {
-			panic(err)
+		for _, release := range releases {
+			if release.Draft || release.Prerelease {
+				continue
+			}
+			version, err := index.ParseVersion(release.TagName)
+			if err != nil {
+				continue // not a release we'd know how to deal with
+			}
+			knownVersions = append(knownVersions, version)
 		}
-		versionRaw, okNameSet := releaseJson["name"]
-		versionStr, okNameStr := versionRaw.(string)
-		if !okNameSet || !okNameStr {
-			return "", fmt.Errorf("erro cannot read release name at: %s", urlRelease)
+		if len(releases) < terragruntReleasesPerPage {
+			break
 		}
-		versionToDownload = versionStr
 	}
+	if len(knownVersions) == 0 {
+		return nil, fmt.Errorf("no releases found at '%s'", releasesUrl)
+	}
+	return knownVersions, nil
+}
 
-	pathToVersionDir := filepath.Join(
-		terragruntCacheDir,
-		versionToDownload,
-		fmt.Sprintf("%s_%s", runtime.GOOS, runtime.GOARCH),
-	)
-	pathToExecutable := filepath.Join(pathToVersionDir, terragruntExec)
-	if utils.PathExists(pathToExecutable) {
-		// already downloaded & cached
-		// given that checksums published for archives we will check them when fetching binaries and before unpacking
-		return pathToVersionDir, nil
+// listTerragruntVersionsDir treats every sub-dir named after a release tag (like vX.Y.Z) as a release
+func listTerragruntVersionsDir(releasesUrl string) (index.Versions, error) {
+	path, err := utils.UrlToPath(releasesUrl)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var knownVersions index.Versions
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		version, err := index.ParseVersion(entry.Name())
+		if err != nil {
+			continue // not a release we'd know how to deal with
+		}
+		knownVersions = append(knownVersions, version)
+	}
+	if len(knownVersions) == 0 {
+		return nil, fmt.Errorf("no releases found at '%s'", releasesUrl)
 	}
+	return knownVersions, nil
+}
+
+// terragruntTool downloads Terragrunt releases and verifies them against published checksums
+type terragruntTool struct {
+	config   ToolsConfig
+	cacheDir string
+	refresh  time.Duration
+}
+
+func (t *terragruntTool) name() string {
+	return terragruntExec
+}
+
+func (t *terragruntTool) toolCacheDir() string {
+	return filepath.Join(t.cacheDir, terragruntExec)
+}
+
+func (t *terragruntTool) versionName(version *index.Version) string {
+	return "v" + version.Core()
+}
+
+func (t *terragruntTool) listVersions(all bool) (index.Versions, error) {
+	return listTerragruntVersions(t.config.terragruntReleases(), t.toolCacheDir(), t.refresh, all)
+}
+
+func (t *terragruntTool) findDownload(version *index.Version, platform string) (*toolDownload, error) {
 	// windows binary has .exe suffix but there is no FUSE on windows so there is no para on windows ¯\_(ツ)_/¯
-	expectedFileName := terragruntExec + "_" + runtime.GOOS + "_" + runtime.GOARCH
-	urlVersionPrefix := utils.UrlJoin(terragruntDownload, versionToDownload)
+	expectedFileName := terragruntExec + "_" + platform
+	urlVersionPrefix := utils.UrlJoin(t.config.terragruntDownloads(), t.versionName(version))
 	urlVersionChecksums := utils.UrlJoin(urlVersionPrefix, "SHA256SUMS")
 	urlVersionBinary := utils.UrlJoin(urlVersionPrefix, expectedFileName)
 
-	sha256 := findChecksumForFile("sha256:",
-		urlVersionChecksums, expectedFileName,
-		filepath.Join(terragruntCacheDir, "checksums"), refresh,
+	// Terragrunt releases are not signed so checksums is all we've got
+	var problems []error
+	var sha256 string
+	checksums, _, err := utils.DownloadableFile{Url: urlVersionChecksums}.ReadAllWithCache(
+		filepath.Join(t.toolCacheDir(), "checksums"), t.refresh,
 	)
-
-	err := utils.DownloadableFile{
-		Url:    urlVersionBinary,
-		Digest: sha256,
-	}.SaveTo(pathToExecutable)
+	if err == nil {
+		sha256, err = findChecksumForFile("sha256:", urlVersionChecksums, checksums, expectedFileName)
+	} else {
+		err = &VerificationError{Url: urlVersionChecksums, Artifact: expectedFileName, Reason: err}
+	}
 	if err != nil {
-		return "", err
+		problems = append(problems, err)
 	}
 
-	return pathToVersionDir, nil
+	return &toolDownload{
+		File:     utils.DownloadableFile{Url: urlVersionBinary, Digest: sha256},
+		Problems: problems,
+	}, nil
 }
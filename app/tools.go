@@ -0,0 +1,202 @@
+package app
+
+import (
+	"fmt"
+	"github.com/paraterraform/para/app/index"
+	"github.com/paraterraform/para/utils"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const toolVersionsFlag = "tools"
+
+// toolVersionArgs tell how to ask tools found on $PATH for their versions - only known tools are asked
+var toolVersionArgs = map[string][]string{
+	terraformExec:  {"version"},
+	terragruntExec: {"--version"},
+}
+
+var toolVersionRe = regexp.MustCompile(`\bv?(\d+\.\d+\.\d+(?:-[0-9A-Za-z.-]+)?)`)
+
+// tool knows where versions of an executable come from and how to download and verify them
+type tool interface {
+	name() string
+	toolCacheDir() string
+	// versionName tells how the version is named in the cache (and in URLs)
+	versionName(version *index.Version) string
+	// listVersions may list just the latest version unless all of them are asked for
+	listVersions(all bool) (index.Versions, error)
+	// findDownload finds the download for the platform (in the same format as used in the index)
+	findDownload(version *index.Version, platform string) (*toolDownload, error)
+}
+
+// toolDownload describes how to download a given version of a tool for the current platform and how it was verified
+type toolDownload struct {
+	File     utils.DownloadableFile
+	Size     uint64 // size of the executable, 0 if unknown
+	Signer   string
+	Problems []error // anything that prevents verification - fatal unless verification is relaxed
+}
+
+// findTool picks where the tool comes from - the index takes precedence so that it can provide (and pin) even
+// Terraform and Terragrunt which are downloaded from upstream (or mirrors) otherwise. Returns nil for unknown tools.
+func findTool(
+	name string, config ToolsConfig, loadingIndex *index.LoadingIndex, cacheDir string, refresh time.Duration,
+) tool {
+	if len(loadingIndex.NameToTools[name]) > 0 {
+		return &indexTool{toolName: name, index: loadingIndex}
+	}
+	switch name {
+	case terraformExec:
+		return &terraformTool{config: config, cacheDir: cacheDir, refresh: refresh}
+	case terragruntExec:
+		return &terragruntTool{config: config, cacheDir: cacheDir, refresh: refresh}
+	}
+	return nil
+}
+
+// toolTitle is how the tool is called in the summary
+func toolTitle(name string) string {
+	switch name {
+	case terraformExec:
+		return "Terraform"
+	case terragruntExec:
+		return "Terragrunt"
+	}
+	return name
+}
+
+// toolRequirement finds out which version of the tool is required. Terraform and Terragrunt have their own sources of
+// requirements, for the rest it's either 'tools' section in config or .<name>-version file (in the working dir or any
+// of its parents) - in that order. Returns nil if there is no requirement.
+func toolRequirement(name string, config ToolsConfig, workDir string) (*versionRequirement, error) {
+	switch name {
+	case terraformExec:
+		return terraformRequirement(config, workDir)
+	case terragruntExec:
+		return terragruntRequirement(config, workDir)
+	}
+	if raw, ok := config.Versions[name]; ok {
+		return parseVersionRequirement(raw, fmt.Sprintf("%s.%s in config", toolVersionsFlag, name))
+	}
+	return findVersionFile(workDir, "."+name+"-version")
+}
+
+// downloadTool resolves the required version and downloads it for the platform unless it's already cached
+func downloadTool(t tool, requirement *versionRequirement, platform string, insecure bool) (*downloadedTool, error) {
+	version, err := requirement.resolve(func() (index.Versions, error) {
+		return t.listVersions(requirement != nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	versionToDownload := t.versionName(version)
+
+	pathToVersionDir := filepath.Join(t.toolCacheDir(), versionToDownload, platform)
+	pathToExecutable := filepath.Join(pathToVersionDir, t.name())
+	if isVerifiedExecutable(pathToExecutable, insecure) {
+		// already downloaded, verified & cached
+		touchCacheEntry(pathToExecutable)
+		return &downloadedTool{Dir: pathToVersionDir, Version: versionToDownload}, nil
+	}
+
+	// other processes sharing the cache dir may be downloading the same tool - let's wait for them and check again
+	lock, err := utils.LockCacheEntry(pathToExecutable)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = lock.Unlock() }()
+	if isVerifiedExecutable(pathToExecutable, insecure) {
+		return &downloadedTool{Dir: pathToVersionDir, Version: versionToDownload}, nil
+	}
+
+	download, err := t.findDownload(version, platform)
+	if err != nil {
+		return nil, err
+	}
+	if len(download.Problems) > 0 && !insecure {
+		return nil, download.Problems[0]
+	}
+
+	download.File.PartialDir = filepath.Join(t.toolCacheDir(), "partial")
+	err = saveVerifiedExecutable(download.File, pathToExecutable, download.Size)
+	if err != nil {
+		return nil, err
+	}
+
+	return &downloadedTool{
+		Dir:          pathToVersionDir,
+		Version:      versionToDownload,
+		Verification: describeVerification(download.Problems, download.File.Digest, download.Signer),
+	}, nil
+}
+
+// existingToolVersion tells the version of the tool found on $PATH, nil if it's unknown or cannot be determined
+func existingToolVersion(name, path string) *index.Version {
+	args, ok := toolVersionArgs[name]
+	if !ok {
+		return nil
+	}
+	cmd := exec.Command(path, args...)
+	cmd.Env = append(os.Environ(), "CHECKPOINT_DISABLE=1") // otherwise Terraform checks for updates online
+	output, err := cmd.Output()
+	if err != nil {
+		return nil
+	}
+	// e.g. "Terraform v0.12.31" or "terragrunt version v0.28.24" followed by other details
+	match := toolVersionRe.FindStringSubmatch(strings.SplitN(string(output), "\n", 2)[0])
+	if match == nil {
+		return nil
+	}
+	version, err := index.ParseVersion(match[1])
+	if err != nil {
+		return nil
+	}
+	return version
+}
+
+// runtimePlatform is the platform Para runs on in the same format as used in the index
+func runtimePlatform() string {
+	return runtime.GOOS + "_" + runtime.GOARCH
+}
+
+// indexTool is listed in the 'tools' section of the primary index (or in a tools.<name>.yaml extension) - it's
+// verified against the digest from the index which is as trustworthy as the index itself
+type indexTool struct {
+	toolName string
+	index    *index.LoadingIndex
+}
+
+func (t *indexTool) name() string {
+	return t.toolName
+}
+
+func (t *indexTool) toolCacheDir() string {
+	return filepath.Join(t.index.CacheDir, index.SectionTools, t.toolName)
+}
+
+func (t *indexTool) versionName(version *index.Version) string {
+	return "v" + version.Core()
+}
+
+func (t *indexTool) listVersions(bool) (index.Versions, error) {
+	return t.index.ListToolVersions(t.toolName, runtimePlatform()), nil
+}
+
+func (t *indexTool) findDownload(version *index.Version, platform string) (*toolDownload, error) {
+	found := t.index.FindTool(t.toolName, version, platform)
+	if found == nil {
+		return nil, fmt.Errorf(
+			"there is no build of %s %s for %s in the index", t.toolName, t.versionName(version), platform,
+		)
+	}
+	return &toolDownload{
+		File: utils.DownloadableFile{Url: found.Url, Digest: found.Digest, ExtractPattern: t.toolName + "*"},
+		Size: found.Size,
+	}, nil
+}
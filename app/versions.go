@@ -0,0 +1,227 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/paraterraform/para/app/index"
+	"github.com/paraterraform/para/utils"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	versionLatest       = "latest"
+	versionLatestPrefix = "latest:"
+)
+
+// heredocs are kept as is since neither comments nor quotes have any meaning within them
+var hclHeredocRe = regexp.MustCompile(`^<<-?([A-Za-z_][\w-]*)\r?\n`)
+
+// versionRequirement describes which versions of a tool are acceptable and where the requirement comes from
+type versionRequirement struct {
+	Source      string            // shown to the user, e.g. "required_version in main.tf"
+	Constraints index.Constraints // nil means any version
+	Pattern     *regexp.Regexp    // tfenv-style "latest:<regex>"
+	Prerelease  bool              // consider pre-releases even if constraints don't name any
+}
+
+func (r *versionRequirement) String() string {
+	var result string
+	switch {
+	case r.Pattern != nil:
+		result = versionLatestPrefix + r.Pattern.String()
+	case r.Constraints == nil:
+		result = versionLatest
+	default:
+		result = r.Constraints.String()
+	}
+	if r.Prerelease {
+		result += " (including pre-releases)"
+	}
+	return result
+}
+
+// parseVersionRequirement parses "latest", "latest:<regex>" or a version constraint like "1.2.3" or "~> 1.2"
+func parseVersionRequirement(raw, source string) (*versionRequirement, error) {
+	raw = strings.TrimSpace(raw)
+	requirement := &versionRequirement{Source: source}
+	switch {
+	case raw == versionLatest:
+	case strings.HasPrefix(raw, versionLatestPrefix):
+		pattern, err := regexp.Compile(raw[len(versionLatestPrefix):])
+		if err != nil {
+			return nil, fmt.Errorf("malformed version pattern '%s' in %s: %s", raw, source, err)
+		}
+		requirement.Pattern = pattern
+	default:
+		constraints, err := index.ParseConstraints(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s in %s", err, source)
+		}
+		requirement.Constraints = constraints
+	}
+	return requirement, nil
+}
+
+// resolve picks the newest known version satisfying the requirement (nil requirement means just the newest release),
+// known versions are only listed when the requirement is not an exact version
+func (r *versionRequirement) resolve(listVersions func() (index.Versions, error)) (*index.Version, error) {
+	if r != nil && r.Pattern == nil {
+		if exact := r.Constraints.Exact(); exact != nil {
+			return exact, nil
+		}
+	}
+
+	known, err := listVersions()
+	if err != nil {
+		return nil, fmt.Errorf("cannot list known versions: %s", err)
+	}
+	if r == nil {
+		r = &versionRequirement{Source: "default"}
+	}
+
+	var result *index.Version
+	for _, version := range known {
+		if r.accepts(version) && (result == nil || version.GreaterThan(result)) {
+			result = version
+		}
+	}
+	if result == nil {
+		return nil, fmt.Errorf("none of %d known versions satisfies '%s' (%s)", len(known), r, r.Source)
+	}
+	return result, nil
+}
+
+// satisfiedBy tells whether a version that is already available (e.g. on $PATH) can be used as is - only constraints
+// can be satisfied that way as "latest" asks for the newest version
+func (r *versionRequirement) satisfiedBy(version *index.Version) bool {
+	return r.Pattern == nil && r.Constraints != nil && r.accepts(version)
+}
+
+func (r *versionRequirement) accepts(version *index.Version) bool {
+	if r.Pattern != nil && !r.Pattern.MatchString(version.Core()) {
+		return false
+	}
+	if !version.IsPrerelease() || !r.Prerelease {
+		return r.Constraints.Check(version) // takes care of pre-releases named by constraints
+	}
+	for _, constraint := range r.Constraints {
+		if !constraint.Check(version) {
+			return false
+		}
+	}
+	return true
+}
+
+// findVersionFile walks up from the dir looking for a file with the given name the same way tfenv and tgenv do
+func findVersionFile(dir, name string) (*versionRequirement, error) {
+	for {
+		path := filepath.Join(dir, name)
+		if utils.PathExists(path) {
+			content, err := ioutil.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			// tfenv takes the first line and tolerates "v" prefix
+			raw := strings.TrimSpace(strings.SplitN(string(content), "\n", 2)[0])
+			raw = strings.TrimPrefix(raw, "v")
+			return parseVersionRequirement(raw, utils.PathSimplify(path))
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil, nil
+		}
+		dir = parent
+	}
+}
+
+// stripHclComments drops comments from HCL content - it's good enough to ignore commented out attributes without
+// parsing files completely. Quoted strings (including templates nested in them) are kept intact so that '#', '//' and
+// '/*' within them are not mistaken for comments.
+func stripHclComments(content []byte) []byte {
+	result := make([]byte, 0, len(content))
+	var templates []int // brace depths at which templates within strings were opened, innermost last
+	depth := 0
+	inString := false
+	for idx := 0; idx < len(content); idx++ {
+		c := content[idx]
+		var next byte
+		if idx+1 < len(content) {
+			next = content[idx+1]
+		}
+
+		if inString {
+			result = append(result, c)
+			switch {
+			case c == '\\' && next != 0, (c == '$' || c == '%') && next == c: // escapes
+				idx++
+				result = append(result, next)
+			case (c == '$' || c == '%') && next == '{':
+				idx++
+				result = append(result, next)
+				depth++
+				templates = append(templates, depth)
+				inString = false
+			case c == '"':
+				inString = false
+			}
+			continue
+		}
+
+		switch {
+		case c == '#' || c == '/' && next == '/':
+			for idx+1 < len(content) && content[idx+1] != '\n' {
+				idx++
+			}
+			continue
+		case c == '/' && next == '*':
+			end := bytes.Index(content[idx+2:], []byte("*/"))
+			if end < 0 {
+				return result
+			}
+			idx += end + 3
+			continue
+		case c == '<' && next == '<':
+			if length := hclHeredocLength(content[idx:]); length > 0 {
+				result = append(result, content[idx:idx+length]...)
+				idx += length - 1
+				continue
+			}
+		case c == '"':
+			inString = true
+		case c == '{':
+			depth++
+		case c == '}':
+			if last := len(templates) - 1; last >= 0 && templates[last] == depth {
+				templates = templates[:last]
+				inString = true
+			}
+			depth--
+		}
+		result = append(result, c)
+	}
+	return result
+}
+
+// hclHeredocLength tells the length of the heredoc the content starts with (up to its closing marker), 0 if none
+func hclHeredocLength(content []byte) int {
+	match := hclHeredocRe.FindSubmatch(content)
+	if match == nil {
+		return 0
+	}
+	length := len(match[0])
+	for length < len(content) {
+		line := content[length:]
+		if end := bytes.IndexByte(line, '\n'); end >= 0 {
+			line = line[:end]
+		}
+		length += len(line)
+		if bytes.Equal(bytes.TrimSpace(line), match[1]) {
+			return length
+		}
+		length++ // newline
+	}
+	return len(content) // unterminated
+}
@@ -0,0 +1,63 @@
+package app
+
+import (
+	"github.com/paraterraform/para/app/index"
+	"testing"
+)
+
+func TestStripHclComments(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		content  string
+		expected string
+	}{
+		{"hash", "a = 1 # comment\nb = 2", "a = 1 \nb = 2"},
+		{"slashes", "a = 1 // comment\nb = 2", "a = 1 \nb = 2"},
+		{"block", "a = /* x\n y */ 1", "a =  1"},
+		{"unterminated block", "a = 1 /* x", "a = 1 "},
+		{"commented out", "# required_version = \"1.0.0\"\n", "\n"},
+		{"hash in string", `url = "http://example.com/#anchor" # comment`, `url = "http://example.com/#anchor" `},
+		{"slashes in string", `url = "https://example.com" // comment`, `url = "https://example.com" `},
+		{"block in string", `glob = "/*.tf" /* comment */`, `glob = "/*.tf" `},
+		{"escaped quote", `a = "\"#" # comment`, `a = "\"#" `},
+		{"template", `a = "${lookup(m, "#")}#" # comment`, `a = "${lookup(m, "#")}#" `},
+		{"nested braces", `a = "${{b = "#"}}" # comment`, `a = "${{b = "#"}}" `},
+		{"escaped template", `a = "$${" # comment`, `a = "$${" `},
+		{"heredoc", "a = <<EOF\n\"# not a comment\nEOF\n# comment", "a = <<EOF\n\"# not a comment\nEOF\n"},
+		{"indented heredoc", "a = <<-EOT\n  // x\n  EOT\nb = 1 // c", "a = <<-EOT\n  // x\n  EOT\nb = 1 "},
+		{"not a heredoc", "a = 1 << 2 # comment", "a = 1 << 2 "},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if actual := string(stripHclComments([]byte(tc.content))); actual != tc.expected {
+				t.Fatalf("expected %q, got %q", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestVersionRequirementSatisfiedBy(t *testing.T) {
+	for _, tc := range []struct {
+		requirement string
+		version     string
+		satisfied   bool
+	}{
+		{"~> 0.12.0", "v0.12.31", true},
+		{"~> 0.12.0", "v0.13.0", false},
+		{">= 0.12", "v1.5.7", true},
+		{"0.12.31", "v0.12.31", true},
+		{"0.12.31", "v0.12.30", false},
+		{">= 1.0", "v1.6.0-beta1", false},
+		{"latest", "v1.5.7", false},
+		{"latest:^1.5", "v1.5.7", false},
+	} {
+		t.Run(tc.requirement+"@"+tc.version, func(t *testing.T) {
+			requirement, err := parseVersionRequirement(tc.requirement, "test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if requirement.satisfiedBy(index.MustParseVersion(tc.version)) != tc.satisfied {
+				t.Fatalf("expected satisfied to be %v", tc.satisfied)
+			}
+		})
+	}
+}
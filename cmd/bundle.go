@@ -0,0 +1,132 @@
+package cmd
+
+import (
+	"github.com/paraterraform/para/app"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	flagOutput         = "output"
+	flagPinned         = "pinned"
+	flagPlatforms      = "platforms"
+	flagWithTerraform  = "with-terraform"
+	flagWithTerragrunt = "with-terragrunt"
+	flagIndexOutput    = "index-output"
+	flagForce          = "force"
+
+	defaultBundle      = "para-bundle.tar.gz"
+	defaultIndexOutput = "para.idx.yaml"
+)
+
+var optionOutput string
+var optionPinned bool
+var optionPlatforms []string
+var optionWithTerraform []string
+var optionWithTerragrunt []string
+var optionIndexOutput string
+var optionForce bool
+
+var bundleCmd = &cobra.Command{
+	Use:   "bundle <command> [flags]",
+	Short: "Export and import bundles of plugins and tools for air-gapped environments",
+	Long: `
+A bundle is a single tarball with plugins from the index and given versions of Terraform and Terragrunt along with a
+manifest that lists sha256 digests of all of them. It's exported where there is network access and imported where there
+is none - into the cache dir along with an index that lists everything in the bundle by file:// URLs so that Para
+(pointed at that index) does not need the network anymore.
+`,
+}
+
+var bundleExportCmd = &cobra.Command{
+	Use:   "export [flags]",
+	Short: "Download plugins from the index and given tools and pack them into a bundle",
+	Long: `
+Resolves the index the same way it's resolved when Terraform is run (including pins and the lock file) and bundles all
+of the plugins it lists (or just pinned ones with --pinned) for all platforms (or just the given ones with --platforms).
+Terraform and Terragrunt are bundled for given platforms (or the current one) in versions given by --with-terraform and
+--with-terragrunt (exact versions, constraints or 'latest').
+`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		app.BundleExport(
+			readIndexConfig(), viper.GetString(flagCache), viper.GetDuration(flagRefresh), readToolsConfig(),
+			optionOutput, optionPinned, optionPlatforms,
+			map[string][]string{"terraform": optionWithTerraform, "terragrunt": optionWithTerragrunt},
+		)
+	},
+}
+
+var bundleImportCmd = &cobra.Command{
+	Use:   "import [flags] <bundle>",
+	Short: "Verify and unpack a bundle into the cache dir and generate an index for it",
+	Long: `
+Verifies sizes and digests of everything in the bundle, unpacks it to <cache dir>/bundles/<id> and generates an index
+that lists bundled plugins by kinds and bundled tools in the tools section (so that they take precedence over upstream)
+by file:// URLs. The generated index is para.idx.yaml in the working dir by default so that Para picks it up right away.
+
+Bundled files are listed by their sha256 digests along with digests from the original index (index-digest) so that
+lock files created against the original index keep matching plugins even if they were extracted from archives.
+`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		app.BundleImport(viper.GetString(flagCache), args[0], optionIndexOutput, optionForce)
+	},
+}
+
+func init() {
+	bundleCmd.SetUsageTemplate(groupCommandUsageTemplate)
+	for _, subCmd := range []*cobra.Command{bundleExportCmd, bundleImportCmd} {
+		subCmd.Flags().SortFlags = false
+		subCmd.SetUsageTemplate(subCommandUsageTemplate)
+		bundleCmd.AddCommand(subCmd)
+	}
+
+	bundleExportCmd.Flags().StringVarP(
+		&optionOutput,
+		flagOutput,
+		"o",
+		defaultBundle,
+		"where to write the bundle",
+	)
+	bundleExportCmd.Flags().BoolVar(
+		&optionPinned,
+		flagPinned,
+		false,
+		"bundle only plugins that are pinned in config",
+	)
+	bundleExportCmd.Flags().StringSliceVar(
+		&optionPlatforms,
+		flagPlatforms,
+		nil,
+		"platforms to bundle plugins and tools for, e.g. linux_amd64,darwin_amd64 (default - all for plugins and "+
+			"current for tools)",
+	)
+	bundleExportCmd.Flags().StringSliceVar(
+		&optionWithTerraform,
+		flagWithTerraform,
+		nil,
+		"Terraform versions to bundle (can be repeated)",
+	)
+	bundleExportCmd.Flags().StringSliceVar(
+		&optionWithTerragrunt,
+		flagWithTerragrunt,
+		nil,
+		"Terragrunt versions to bundle (can be repeated)",
+	)
+
+	bundleImportCmd.Flags().StringVar(
+		&optionIndexOutput,
+		flagIndexOutput,
+		defaultIndexOutput,
+		"where to write the generated index",
+	)
+	bundleImportCmd.Flags().BoolVar(
+		&optionForce,
+		flagForce,
+		false,
+		"overwrite the index if it already exists",
+	)
+
+	rootCmd.AddCommand(bundleCmd)
+}
@@ -0,0 +1,132 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/paraterraform/para/app"
+	"github.com/paraterraform/para/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"os"
+	"time"
+)
+
+const (
+	flagUnlisted  = "unlisted"
+	flagOlderThan = "older-than"
+	flagMaxSize   = "max-size"
+)
+
+var optionUnlisted bool
+var optionOlderThan time.Duration
+var optionMaxSize string
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache <command> [flags]",
+	Short: "List, verify and prune plugins and tools in the cache dir",
+	Long: `
+Para caches plugins (<cache dir>/plugins/<kind>/<name>/<version>/<platform>), tools (<cache dir>/terraform,
+<cache dir>/terragrunt and <cache dir>/tools/<name>) and partial downloads in the cache dir. Every time Para uses a
+cached plugin or tool it updates its modification time so that it's known when it was used last time.
+
+The cache dir can be limited in size with --cache-max-size (or cache-max-size in a config file) in which case least
+recently used entries are pruned whenever Para runs a command if the cache dir grew too big.
+`,
+}
+
+var cacheLsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List cached plugins, tools and partial downloads with their sizes and last use times",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		app.CacheList(viper.GetString(flagCache))
+	},
+}
+
+var cacheVerifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Re-verify cached plugins and tools and remove corrupted ones",
+	Long: `
+Cached plugins are verified against sizes in the current index as well as digests unless they were extracted from
+archives. Cached tools are verified against digests recorded when they were downloaded. Corrupted entries are removed so
+that they are downloaded once again when needed.
+`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		app.CacheVerify(readIndexConfig(), viper.GetString(flagCache), viper.GetDuration(flagRefresh))
+	},
+}
+
+var cachePruneCmd = &cobra.Command{
+	Use:   "prune [flags]",
+	Short: "Remove plugins and tools no longer in the index, not used for a while or least recently used ones",
+	Long: `
+Removes (in that order and only if requested):
+  * plugins and tools provided by the index that are no longer in it (--unlisted)
+  * plugins, tools and partial downloads that were not used for a given time (--older-than)
+  * least recently used plugins, tools and partial downloads until the cache dir fits the size (--max-size which
+    defaults to cache-max-size if it's configured)
+`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		rawMaxSize := optionMaxSize
+		if rawMaxSize == "" {
+			rawMaxSize = viper.GetString(flagCacheMaxSize)
+		}
+		app.CachePrune(
+			readIndexConfig(), viper.GetString(flagCache), viper.GetDuration(flagRefresh),
+			optionUnlisted, optionOlderThan, parseSizeOrExit(rawMaxSize),
+		)
+	},
+}
+
+var cachePurgeCmd = &cobra.Command{
+	Use:   "purge",
+	Short: "Remove everything from the cache dir",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		app.CachePurge(viper.GetString(flagCache))
+	},
+}
+
+// parseSizeOrExit parses an optional size, an empty one means no limit
+func parseSizeOrExit(raw string) int64 {
+	if raw == "" {
+		return 0
+	}
+	size, err := utils.SizeParse(raw)
+	if err != nil {
+		fmt.Printf("* Error: %s\n", err)
+		os.Exit(1)
+	}
+	return size
+}
+
+func init() {
+	cacheCmd.SetUsageTemplate(groupCommandUsageTemplate)
+	for _, subCmd := range []*cobra.Command{cacheLsCmd, cacheVerifyCmd, cachePruneCmd, cachePurgeCmd} {
+		subCmd.Flags().SortFlags = false
+		subCmd.SetUsageTemplate(subCommandUsageTemplate)
+		cacheCmd.AddCommand(subCmd)
+	}
+
+	cachePruneCmd.Flags().BoolVar(
+		&optionUnlisted,
+		flagUnlisted,
+		false,
+		"remove plugins and tools provided by the index that are no longer in it",
+	)
+	cachePruneCmd.Flags().DurationVar(
+		&optionOlderThan,
+		flagOlderThan,
+		0,
+		"remove entries that were not used for a given time (e.g. 720h)",
+	)
+	cachePruneCmd.Flags().StringVar(
+		&optionMaxSize,
+		flagMaxSize,
+		"",
+		"remove least recently used entries until the cache dir fits the size (e.g. 2GB, default - cache-max-size)",
+	)
+
+	rootCmd.AddCommand(cacheCmd)
+}
@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"github.com/paraterraform/para/app"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	flagSources = "sources" // config only
+
+	flagWrite         = "write"
+	flagTerraformLock = "terraform-lock"
+
+	defaultTerraformLock = ".terraform.lock.hcl"
+)
+
+var optionWrite bool
+var optionTerraformLock string
+
+var hashesCmd = &cobra.Command{
+	Use:   "hashes [flags] [provider.<name>...]",
+	Short: "Compute Terraform package hashes (h1: and zh:) for locked providers",
+	Long: `
+Terraform 0.14+ verifies providers against hashes recorded in its dependency lock file (.terraform.lock.hcl). This
+command computes Terraform-compatible hashes for providers recorded in Para's lock file (or just the given ones):
+  * h1: - a hash of files in the provider archive (or of the binary if not archived), for every locked platform
+  * zh: - a sha256 of the zip archive, only when the index provides a sha256 digest for a .zip URL
+
+With --write hashes are merged into .terraform.lock.hcl: hashes are added to providers of the same version while
+providers of other versions get new version and hashes, everything else in the file is left as it is.
+
+Terraform identifies providers by source addresses and Para assumes the same address Terraform does for providers
+without an explicit source (registry.terraform.io/hashicorp/<name>). It can be overridden per provider in config:
+
+    sources:
+      provider.<name>: <hostname>/<namespace>/<name>
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		app.Hashes(
+			readIndexConfig(), viper.GetString(flagCache), viper.GetDuration(flagRefresh),
+			viper.GetStringMapString(flagSources), args, optionTerraformLock, optionWrite,
+		)
+	},
+}
+
+func init() {
+	hashesCmd.Flags().SortFlags = false
+	hashesCmd.SetUsageTemplate(subCommandUsageTemplate)
+	hashesCmd.Flags().BoolVar(
+		&optionWrite,
+		flagWrite,
+		false,
+		"merge computed hashes into Terraform's dependency lock file",
+	)
+	hashesCmd.Flags().StringVar(
+		&optionTerraformLock,
+		flagTerraformLock,
+		defaultTerraformLock,
+		"Terraform's dependency lock file to merge hashes into",
+	)
+
+	rootCmd.AddCommand(hashesCmd)
+}
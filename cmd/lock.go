@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"github.com/paraterraform/para/app"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const flagUpdate = "update"
+
+var optionUpdate bool
+
+var lockCmd = &cobra.Command{
+	Use:   "lock [flags] [<kind>.<name>...]",
+	Short: "Verify the lock file against the index or re-resolve locked plugins",
+	Long: `
+Para records every plugin it serves during a successful run in a lock file (para.lock.yaml next to the config file by
+default) that has the same format as the primary index. Subsequent runs only expose locked versions for locked
+platforms and refuse to start if the index provides a different digest for any of them or if a locked version is not
+locked for the current platform.
+
+Without flags this command verifies that the lock file is consistent with the current index. With --update it
+re-resolves locked plugins (or just the given ones - they don't have to be locked already) to the newest version
+available in the index that satisfies pins (if any) and updates the lock file for all platforms locked before as well
+as the current platform.
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		app.Lock(
+			readIndexConfig(), viper.GetString(flagCache), viper.GetDuration(flagRefresh), optionUpdate, args,
+		)
+	},
+}
+
+func init() {
+	lockCmd.Flags().SortFlags = false
+	lockCmd.SetUsageTemplate(subCommandUsageTemplate)
+	lockCmd.Flags().BoolVar(
+		&optionUpdate,
+		flagUpdate,
+		false,
+		"re-resolve locked plugins (or the given ones) and update the lock file",
+	)
+
+	rootCmd.AddCommand(lockCmd)
+}
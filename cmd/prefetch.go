@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"github.com/paraterraform/para/app"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var prefetchCmd = &cobra.Command{
+	Use:   "prefetch [flags]",
+	Short: "Download plugins to the cache dir without running Terraform",
+	Long: `
+Para downloads plugins only when Terraform opens them so the first 'terraform init' pays for all the downloads. This
+command downloads plugins listed by the index (narrowed down by pins and the lock file the same way it's done when
+Terraform is run) for given platforms (or the current one) to the cache dir right away - e.g. to warm up the cache in
+CI or to bake plugins into a Docker image. Plugins that are already cached are re-verified and downloaded once again if
+they are corrupted.
+
+Exits with a non-zero code if any plugin could not be downloaded or failed verification against the index.
+`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		app.Prefetch(
+			readIndexConfig(), viper.GetString(flagCache), viper.GetDuration(flagRefresh), optionPlatforms, optionPinned,
+		)
+	},
+}
+
+func init() {
+	prefetchCmd.Flags().SortFlags = false
+	prefetchCmd.SetUsageTemplate(subCommandUsageTemplate)
+	prefetchCmd.Flags().StringSliceVar(
+		&optionPlatforms,
+		flagPlatforms,
+		nil,
+		"platforms to download plugins for, e.g. linux_amd64,darwin_amd64 (default - current)",
+	)
+	prefetchCmd.Flags().BoolVar(
+		&optionPinned,
+		flagPinned,
+		false,
+		"download only plugins that are pinned in config",
+	)
+
+	rootCmd.AddCommand(prefetchCmd)
+}
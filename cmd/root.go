@@ -4,9 +4,11 @@ import (
 	"bazil.org/fuse"
 	"fmt"
 	"github.com/paraterraform/para/app"
+	"github.com/paraterraform/para/app/index"
 	"github.com/paraterraform/para/utils"
 	"github.com/spf13/viper"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -18,16 +20,70 @@ const (
 	flagUnmount = "unmount"
 
 	flagIndex      = "index"
+	flagIndices    = "indices" // config only
+	flagIndexMerge = "index-merge"
 	flagExtensions = "extensions"
 	flagCache      = "cache"
 	flagRefresh    = "refresh"
-
-	flagTerraform = "terraform"
-	flagTerragrunt = "terragrunt"
+	flagDownloads  = "parallel-downloads"
+	flagMode       = "mode"
+
+	flagCacheMaxSize = "cache-max-size"
+
+	flagPlugins   = "plugins" // config only
+	flagPinLatest = "pin-latest"
+	flagLock      = "lock"
+
+	flagIndexKeys   = "index-keys" // config only
+	flagIndexSigned = "index-signed"
+
+	flagTerraform           = "terraform"
+	flagTerraformPrerelease = "terraform-prerelease"
+	flagTerragrunt          = "terragrunt"
+	flagTools               = "tools" // config only
+
+	flagTerraformKeys = "terraform-keys" // config only
+	flagInsecure      = "insecure"
+
+	flagHttpAuth           = "http-auth" // config only
+	flagHttpConnectTimeout = "http-connect-timeout"
+	flagHttpReadTimeout    = "http-read-timeout"
+	flagHttpRetries        = "http-retries"
+	flagHttpCaBundle       = "http-ca-bundle"
+	flagOffline            = "offline"
+	envOffline             = "PARA_OFFLINE"
+
+	flagTerraformReleases   = "terraform-releases"
+	flagTerraformDownloads  = "terraform-downloads"
+	flagTerragruntReleases  = "terragrunt-releases"
+	flagTerragruntDownloads = "terragrunt-downloads"
 )
 
-const usageTemplate = `Flags:
+const usageTemplate = `Commands:{{range .Commands}}{{if .IsAvailableCommand}}
+  {{rpad .Name .NamePadding }} {{.Short}}{{end}}{{end}}
+
+Flags:
+{{.LocalFlags.FlagUsages | trimTrailingWhitespaces}}
+`
+
+const subCommandUsageTemplate = `Usage:
+  {{.UseLine}}
+
+Flags:
 {{.LocalFlags.FlagUsages | trimTrailingWhitespaces}}
+
+Global Flags:
+{{.InheritedFlags.FlagUsages | trimTrailingWhitespaces}}
+`
+
+const groupCommandUsageTemplate = `Usage:
+  {{.UseLine}}
+
+Commands:{{range .Commands}}{{if .IsAvailableCommand}}
+  {{rpad .Name .NamePadding }} {{.Short}}{{end}}{{end}}
+
+Global Flags:
+{{.InheritedFlags.FlagUsages | trimTrailingWhitespaces}}
 `
 
 const helpShort = `
@@ -44,7 +100,8 @@ Overview
   and ~/.terraform.d/plugins - see https://www.terraform.io/docs/extend/how-terraform-works.html#plugin-locations for
   details) and downloads them on demand (with optional caching) using a curated index (or your own).
 	
-  Please note that FUSE must be available (macOS requires OSXFUSE - https://osxfuse.github.io).
+  FUSE is preferred (macOS requires OSXFUSE - https://osxfuse.github.io) but where it's unavailable (e.g. in
+  containers) plugins are downloaded upfront and linked into the plugin dir instead (see 'para -h').
 
 Usage:
   para terraform [flags] <command> [args]
@@ -70,6 +127,10 @@ var defaultIndexCandidates = []string{
 	"https://raw.githubusercontent.com/paraterraform/index/master/para.idx.yaml",
 }
 
+const defaultLockFile = "para.lock.yaml"
+
+const defaultParallelDownloads = 4
+
 var defaultExtensionsCandidates = []string{
 	"para.idx.d",
 	"~/.para/para.idx.d",
@@ -80,6 +141,7 @@ var optionConfig string
 var optionUnmount string
 
 var rootCmd = &cobra.Command{
+	Use: "para",
 
 	Long: `
 Para - the missing community plugin manager for Terraform.
@@ -99,6 +161,7 @@ Concepts
                digest: <md5|sha1|sha256|sha512>:<hash of the file that will be download - verified before extraction>
 
     All strings (key & values, except for URLs) must be lowercase. All fields are required (url, size, digest).
+    Versions must follow semantic versioning as vX.Y.Z[-pre] - malformed ones are reported and ignored.
 
     URLs may point to archives and they will be automatically extracted (size MUST be always derived from the actual
     plugin binary and digest MUST be derived from the archive in such cases) if supported (determined by the extension
@@ -112,6 +175,30 @@ Concepts
       * .tar.sz  or .tsz
       * .rar
 
+  Index Includes
+    A primary index may include other indices (paths or URLs, relative ones are resolved against the including index)
+    that are loaded in the given order and merged at the version granularity (the including index overrides them):
+
+        include:
+          - <location>
+          - url: <location>
+            refresh: <interval, defaults to --refresh>
+
+    Includes may be nested but cycles are reported as errors. Remote indices may only include remote ones.
+
+  Merging Primary Indices
+    Instead of picking the first available candidate, Para can merge several primary indices (e.g. a private index over
+    the public one) listed in a config file in the order of increasing precedence (--index is ignored then):
+
+        indices:
+          - <location of the base index>
+          - <location of the index that overrides it>
+
+    Later indices override either just versions they list (--index-merge=versions, default) or whole plugins they list
+    (--index-merge=plugins). Every index must be loaded - Para fails if any of them cannot be loaded (or is not signed
+    while signatures are required). The summary tells which index each plugin came from (unless it entirely came from
+    the first one).
+
   Index Extensions
     Can be used to add or override entries in the primary index. May come handy when one is happy with the remote index
     but needs some extra plugins or if one needs to use an alternative implementation for a give plugin.
@@ -134,14 +221,154 @@ Concepts
 
   Cache Dir
     When Para fetches remote files it stores them briefly in the $TMPDIR but then caches them in the designated cache
-    dir. As per the well-known joke, cache invalidation is too ambitious challenge so Para doesn't do anything about it.
+    dir. As per the well-known joke, cache invalidation is too ambitious challenge so Para doesn't do it on its own.
     By default cache is stored in $TMPDIR so that it will be cleared on reboots. It's possible to configure Para to
-    store cache elsewhere but then it's user's responsibility to manage it in case it grows too big.
+    store cache elsewhere and limit its size with --cache-max-size (least recently used plugins and tools are pruned on
+    start) or manage it with 'para cache ls|verify|prune|purge'.
     Cache dir facilitates offline operation. 
+    Plugins can be downloaded ahead of time (e.g. while building a Docker image) with 'para prefetch'.
+    Air-gapped environments can be provisioned with bundles made by 'para bundle export' and unpacked to the cache
+    dir (along with a generated index) by 'para bundle import'.
+    Cache dir can be shared by concurrent Para processes (e.g. parallel CI jobs): files are written to temp files and
+    renamed into place while advisory locks (<entry>.lock files) make processes wait for each other instead of
+    downloading the same plugin or tool twice.
+
+  Plugin Dir Modes
+    By default Para mounts a FUSE file system over the plugin dir so that plugins are downloaded only when Terraform
+    opens them. If FUSE is unavailable (no /dev/fuse or missing privileges as it's common in containers and CI) Para
+    downloads pinned and locked plugins for the current platform upfront and puts symlinks to them in the plugin dir
+    (it fails if no plugins are pinned or locked rather than downloading all of them). With --mode=link Para does the
+    same but downloads all plugins if none are pinned or locked (--mode=copy puts hardlinks or copies if the cache dir
+    is on another file system) - they are removed once Terraform exits (or on the next run if Para didn't exit
+    properly). Files that already exist in the plugin dir are kept as is. The lock file is only updated in FUSE mode
+    since otherwise it's not known which plugins Terraform used.
 
   Config File
     Any of the flags below (except for config itself as well as help and unmount flags) can be provided via a config
     file. It's if value is not provided via a flag, config file is discovered from one of pre-defined locations.
+
+  Plugin Pins
+    By default Para exposes all known versions of all plugins. A config file may restrict versions of some plugins with
+    a 'plugins' section in the format of:
+
+        plugins:
+          <kind>.<name>: <constraint, e.g. "~> 1.2" or ">= 0.4, < 0.6">
+
+    Only versions satisfying the constraint would be exposed (or just the newest of them with --pin-latest). Para
+    fails if none of the known versions satisfies a pin. Plugins that are not pinned are exposed as is.
+
+  Lock File
+    After every successful run Para records plugins it served (kind, name, version, platform, url, size and digest) in
+    a lock file (para.lock.yaml next to the config file by default) that has the same format as the primary index.
+    Subsequent runs only expose locked versions of locked plugins (for platforms they are locked for) and refuse to
+    start if the index provides a different digest for any of them or if a locked version is not locked for the
+    current platform. Use 'para lock --update' to re-resolve locked plugins (and lock them for the current platform).
+
+  Index Signatures
+    A config file may list trusted OpenPGP public keys (RSA or Ed25519; paths, URLs or inline armored blocks):
+
+        index-keys:
+          - ~/.para/index.pub.asc
+
+    Once keys are configured, Para looks for a detached signature (<location>.sig or <location>.asc) next to the
+    primary index and next to every extension file referenced by a URL. A present but invalid signature is a hard
+    failure (Para doesn't fall back to the next index candidate). Unsigned files are accepted unless --index-signed is
+    set - then unsigned index candidates are skipped while unsigned merged indices and extensions are rejected.
+
+  Terraform Version
+    When Terraform is about to be run, Para resolves the required version from (first one wins):
+      * --terraform flag (or config) - an exact version, a constraint like "~> 0.12.0" or "latest"
+      * .terraform-version file (tfenv-compatible: a version, "latest" or "latest:<regex>") in the current dir or any
+        of its parents
+      * required_version constraints in *.tf and *.tf.json files in the current dir
+
+    Terraform found in $PATH is used as is if its version satisfies the requirement's constraints. Otherwise, the
+    newest release (as per releases.hashicorp.com/terraform/index.json) satisfying the requirement is downloaded (or
+    taken from cache) and takes precedence over the one in $PATH. Without any requirement, Terraform found in $PATH is
+    used or the latest release otherwise. Pre-releases are only considered if a constraint names one or if
+    --terraform-prerelease is set.
+
+  Terragrunt Version
+    Same as for Terraform, the required Terragrunt version is resolved from (first one wins):
+      * --terragrunt flag (or config) - an exact version, a constraint like "~> 0.28.0" or "latest"
+      * .terragrunt-version file (tgenv-compatible) in the current dir or any of its parents
+      * terragrunt_version_constraint in the closest terragrunt.hcl (in the current dir or any of its parents)
+
+  Tools
+    Besides plugins, the primary index may list arbitrary executables (e.g. tflint, tfsec, terraform-docs) in a 'tools'
+    section (extensions are named 'tools.<name>.yaml') that follows the same schema as plugins do. Running
+    'para <name> ...' downloads the required version of the tool (or takes it from cache) and puts it in front of $PATH.
+    The required version comes from (first one wins):
+      * 'tools' section in a config file in the format of:
+
+          tools:
+            <name>: <constraint, e.g. "~> 0.30.0" or "latest">
+
+      * .<name>-version file in the current dir or any of its parents
+
+    Without any requirement, the tool found in $PATH is used or the latest version for the current platform otherwise.
+    If the index lists terraform or terragrunt - they are downloaded as listed there rather than from upstream.
+
+  Mirrors
+    Terraform and Terragrunt are downloaded from upstream by default but any of the base URLs can be overridden with
+    flags or in a config file (terraform-releases, terraform-downloads, terragrunt-releases, terragrunt-downloads).
+    Mirrors (including file:// dirs) must be laid out the same way as upstream:
+      * <terraform-releases>/index.json, <terraform-releases>/<version>/index.json and checksums with signatures
+      * <terraform-downloads>/<version>/terraform_<version>_<os>_<arch>.zip
+      * <terragrunt-releases> is either GitHub releases API or a dir with a sub-dir per release tag (like v0.28.7)
+      * <terragrunt-downloads>/<tag>/terragrunt_<os>_<arch> and <terragrunt-downloads>/<tag>/SHA256SUMS
+
+  Network
+    All HTTP requests share the same client: connections are limited by --http-connect-timeout, waiting for response
+    headers or the next chunk of data - by --http-read-timeout. Transient failures (refused or reset connections,
+    timeouts, truncated responses, 5xx and 429 responses) are retried --http-retries times with exponential backoff or
+    after as long as the server asks via Retry-After. Proxies are configured via HTTPS_PROXY, HTTP_PROXY and NO_PROXY
+    environment variables. Extra CA certificates can be trusted with --http-ca-bundle.
+
+    With --offline (or $PARA_OFFLINE) Para never touches the network: remote files are taken from the cache dir no
+    matter how old they are and anything that is not cached fails right away with an error naming the missing URL.
+
+    Interrupted downloads of plugins and tools are kept in the cache dir and resumed on the next attempt (or the next
+    run) with HTTP range requests if the server supports them and the file didn't change. Plugins that are not
+    archives can be read while they are being downloaded except for their last bytes which are only served once the
+    complete file is verified - if it fails verification further reads fail with an I/O error. Different plugins are
+    downloaded in parallel (limited by --parallel-downloads) while the same plugin is downloaded just once.
+
+  HTTP Credentials
+    Private indices, plugins and tools may require credentials which are configured in a config file by URL prefix
+    (the longest matching prefix wins, values may refer to environment variables as ${NAME}):
+
+        http-auth:
+          - prefix: <URL prefix>
+            token: <sent as a bearer token>
+            username: <sent with password via basic auth>
+            password: <...>
+            headers:
+              <name>: <value>
+
+    A prefix matches URLs with the same scheme and host (and port) whose path is the prefix path or is below it
+    (e.g. https://example.com/repo matches https://example.com/repo/a but not https://example.com/repo-other). URLs
+    that don't match any prefix use credentials from ~/.netrc (or $NETRC) for their host if there are any (a netrc
+    that cannot be read is only a warning). Default ports are implied (https://example.com == https://example.com:443).
+    Credentials are never forwarded along redirects to URLs they are not configured for.
+
+  Downloads Verification
+    When Terraform is not available, Para downloads it and verifies the archive against the published SHA256SUMS whose
+    signature is verified against HashiCorp's public key embedded in Para (pinned by its fingerprint). Trusted keys can
+    be overridden in a config file (paths, URLs or inline armored blocks):
+
+        terraform-keys:
+          - ~/.para/hashicorp.asc
+
+    HashiCorp extends expiration of its key from time to time: once the embedded key turns out to be expired, Para
+    fetches the key published at https://www.hashicorp.com/.well-known/pgp-key.txt (trusted only if it has the same
+    fingerprint) unless keys are overridden. A signature made by an expired key is not fatal but needs --insecure.
+
+    Terragrunt releases are not signed so they are verified against published SHA256SUMS only.
+
+    An invalid signature or checksum is always fatal. Para refuses to install Terraform or Terragrunt if the signature
+    or the checksum is missing unless --insecure is set. Cached executables are re-verified against digests recorded
+    when they were downloaded.
 `,
 	Run: func(cmd *cobra.Command, args []string) {
 		if len(optionUnmount) > 0 {
@@ -159,33 +386,106 @@ Concepts
 			os.Exit(1)
 		}
 
-		var indexCandidates []string
-		optionIndex := viper.GetString(flagIndex)
-		if len(optionIndex) > 0 {
-			indexCandidates = append(indexCandidates, optionIndex)
-		} else {
-			indexCandidates = defaultIndexCandidates
-		}
-
-		var extensionsCandidates []string
-		optionExtensions := viper.GetString(flagExtensions)
-		if len(optionExtensions) > 0 {
-			extensionsCandidates = append(extensionsCandidates, optionExtensions)
-		} else {
-			extensionsCandidates = defaultExtensionsCandidates
-		}
-
 		optionCachePath := viper.GetString(flagCache)
 		optionRefresh := viper.GetDuration(flagRefresh)
-
-		optionTerraform := viper.GetString(flagTerraform)
-		optionTerragrunt := viper.GetString(flagTerragrunt)
+		optionCacheMaxSize := parseSizeOrExit(viper.GetString(flagCacheMaxSize))
 		app.Execute(
-			args, indexCandidates, extensionsCandidates, optionCachePath, optionRefresh, optionTerraform, optionTerragrunt,
+			args, readIndexConfig(), optionCachePath, optionRefresh, optionCacheMaxSize, viper.GetString(flagMode),
+			readToolsConfig(),
 		)
 	},
 }
 
+func readToolsConfig() app.ToolsConfig {
+	return app.ToolsConfig{
+		TerraformVersion:    viper.GetString(flagTerraform),
+		TerraformPrerelease: viper.GetBool(flagTerraformPrerelease),
+		TerragruntVersion:   viper.GetString(flagTerragrunt),
+		Versions:            viper.GetStringMapString(flagTools),
+		TerraformKeys:       viper.GetStringSlice(flagTerraformKeys),
+		Insecure:            viper.GetBool(flagInsecure),
+		TerraformReleases:   viper.GetString(flagTerraformReleases),
+		TerraformDownloads:  viper.GetString(flagTerraformDownloads),
+		TerragruntReleases:  viper.GetString(flagTerragruntReleases),
+		TerragruntDownloads: viper.GetString(flagTerragruntDownloads),
+	}
+}
+
+// httpAuth is an entry of the http-auth section of config, values may refer to environment variables as ${NAME}
+type httpAuth struct {
+	Prefix   string            `mapstructure:"prefix"`
+	Token    string            `mapstructure:"token"`
+	Username string            `mapstructure:"username"`
+	Password string            `mapstructure:"password"`
+	Headers  map[string]string `mapstructure:"headers"`
+}
+
+func readHttpCredentials() ([]utils.HttpCredentials, error) {
+	var entries []httpAuth
+	err := viper.UnmarshalKey(flagHttpAuth, &entries)
+	if err != nil {
+		return nil, err
+	}
+
+	var credentials []utils.HttpCredentials
+	for idx, entry := range entries {
+		if entry.Prefix == "" {
+			return nil, fmt.Errorf("entry #%d has no prefix", idx+1)
+		}
+		headers := make(map[string]string)
+		for name, value := range entry.Headers {
+			headers[name] = os.ExpandEnv(value)
+		}
+		credentials = append(credentials, utils.HttpCredentials{
+			Prefix:   entry.Prefix,
+			Token:    os.ExpandEnv(entry.Token),
+			Username: os.ExpandEnv(entry.Username),
+			Password: os.ExpandEnv(entry.Password),
+			Headers:  headers,
+		})
+	}
+	return credentials, nil
+}
+
+func readIndexConfig() app.IndexConfig {
+	var indexCandidates []string
+	optionIndex := viper.GetString(flagIndex)
+	if len(optionIndex) > 0 {
+		indexCandidates = append(indexCandidates, optionIndex)
+	} else {
+		indexCandidates = defaultIndexCandidates
+	}
+
+	var extensionsCandidates []string
+	optionExtensions := viper.GetString(flagExtensions)
+	if len(optionExtensions) > 0 {
+		extensionsCandidates = append(extensionsCandidates, optionExtensions)
+	} else {
+		extensionsCandidates = defaultExtensionsCandidates
+	}
+
+	optionLock := viper.GetString(flagLock)
+	if len(optionLock) == 0 {
+		optionLock = defaultLockFile
+		if configFile := viper.ConfigFileUsed(); configFile != "" {
+			optionLock = filepath.Join(filepath.Dir(configFile), defaultLockFile)
+		}
+	}
+
+	return app.IndexConfig{
+		PrimaryCandidates: indexCandidates,
+		PrimaryLayers:     viper.GetStringSlice(flagIndices),
+		Merge:             viper.GetString(flagIndexMerge),
+		Extensions:        extensionsCandidates,
+		Pins:              viper.GetStringMapString(flagPlugins),
+		PinLatest:         viper.GetBool(flagPinLatest),
+		LockFile:          utils.PathExpand(optionLock),
+		Keys:              viper.GetStringSlice(flagIndexKeys),
+		Signed:            viper.GetBool(flagIndexSigned),
+		ParallelDownloads: viper.GetInt(flagDownloads),
+	}
+}
+
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Println(err)
@@ -198,9 +498,11 @@ func init() {
 
 	cobra.OnInitialize(initConfig)
 	rootCmd.Flags().SetInterspersed(false)
+	rootCmd.PersistentFlags().SortFlags = false
 	rootCmd.Flags().SortFlags = false
+	rootCmd.Args = cobra.ArbitraryArgs // anything but known sub-commands is a command to run
 	rootCmd.SetUsageTemplate(usageTemplate)
-	rootCmd.Flags().StringVarP(
+	rootCmd.PersistentFlags().StringVarP(
 		&optionConfig,
 		flagConfig,
 		"f",
@@ -210,7 +512,7 @@ func init() {
 			strings.Join(defaultConfigCandidates, ", "),
 		),
 	)
-	rootCmd.Flags().StringP(
+	rootCmd.PersistentFlags().StringP(
 		flagIndex,
 		"i",
 		"",
@@ -219,7 +521,15 @@ func init() {
 			strings.Join(defaultIndexCandidates, ", "),
 		),
 	)
-	rootCmd.Flags().StringP(
+	rootCmd.PersistentFlags().String(
+		flagIndexMerge,
+		index.MergeVersions,
+		fmt.Sprintf(
+			"what indices listed under '%s' in config override when merged: %s or %s",
+			flagIndices, index.MergeVersions, index.MergePlugins,
+		),
+	)
+	rootCmd.PersistentFlags().StringP(
 		flagExtensions,
 		"x",
 		"",
@@ -228,36 +538,129 @@ func init() {
 			strings.Join(defaultExtensionsCandidates, ", "),
 		),
 	)
-	rootCmd.Flags().StringP(
+	rootCmd.PersistentFlags().StringP(
 		flagCache,
 		"c",
 		"",
 		"cache dir (default - ~/.cache/para if exists or /tmp/para-$UID)",
 	)
-	rootCmd.Flags().DurationP(
+	rootCmd.PersistentFlags().DurationP(
 		flagRefresh,
 		"r",
 		time.Hour,
 		"attempt to refresh remote indices every given interval",
 	)
+	rootCmd.PersistentFlags().String(
+		flagCacheMaxSize,
+		"",
+		"prune least recently used plugins and tools on start if cache dir grows beyond given size (e.g. 2GB)",
+	)
+	rootCmd.PersistentFlags().Int(
+		flagDownloads,
+		defaultParallelDownloads,
+		"download at most given number of plugins at the same time (0 - no limit)",
+	)
+	rootCmd.PersistentFlags().String(
+		flagMode,
+		"",
+		fmt.Sprintf(
+			"how plugins are provided in the plugin dir: %s, %s or %s (default - %s or %s of pinned and locked plugins "+
+				"if FUSE is unavailable)",
+			app.PluginDirModeFuse, app.PluginDirModeLink, app.PluginDirModeCopy,
+			app.PluginDirModeFuse, app.PluginDirModeLink,
+		),
+	)
+
+	rootCmd.PersistentFlags().String(
+		flagLock,
+		"",
+		fmt.Sprintf("lock file (default - %s next to config file or in current dir)", defaultLockFile),
+	)
+	rootCmd.PersistentFlags().Bool(
+		flagPinLatest,
+		false,
+		"expose only the newest version of each plugin pinned in config (default - all versions satisfying pins)",
+	)
+	rootCmd.PersistentFlags().Bool(
+		flagIndexSigned,
+		false,
+		"require valid signatures for primary index and extensions referenced by URLs (default - verify if present)",
+	)
 
 	// Downloadables
-	rootCmd.Flags().StringP(
+	rootCmd.PersistentFlags().StringP(
 		flagTerraform,
 		"t",
 		"",
-		"Terraform version to download (default - latest)",
+		"Terraform version or constraint (default - from .terraform-version, required_version in *.tf or latest)",
+	)
+	rootCmd.PersistentFlags().Bool(
+		flagTerraformPrerelease,
+		false,
+		"consider Terraform pre-releases (default - only if a constraint names one)",
 	)
 
-	rootCmd.Flags().StringP(
+	rootCmd.PersistentFlags().StringP(
 		flagTerragrunt,
 		"g",
 		"",
-		"Terragrunt version to download (default - latest)",
+		"Terragrunt version or constraint (default - from .terragrunt-version, terragrunt.hcl or latest)",
+	)
+	rootCmd.PersistentFlags().String(
+		flagTerraformReleases,
+		"",
+		"base URL of Terraform releases listing (default - https://releases.hashicorp.com/terraform)",
+	)
+	rootCmd.PersistentFlags().String(
+		flagTerraformDownloads,
+		"",
+		"base URL of Terraform release archives (default - same as Terraform releases)",
+	)
+	rootCmd.PersistentFlags().String(
+		flagTerragruntReleases,
+		"",
+		"base URL of Terragrunt releases API or a dir with releases (default - GitHub API)",
+	)
+	rootCmd.PersistentFlags().String(
+		flagTerragruntDownloads,
+		"",
+		"base URL of Terragrunt release executables (default - GitHub releases)",
+	)
+	rootCmd.PersistentFlags().Bool(
+		flagInsecure,
+		false,
+		"install downloaded tools even if their signatures or checksums are missing (NOT recommended)",
+	)
+
+	// Network
+	rootCmd.PersistentFlags().Duration(
+		flagHttpConnectTimeout,
+		utils.DefaultHttpOptions.ConnectTimeout,
+		"timeout to establish HTTP connections (including TLS handshake)",
+	)
+	rootCmd.PersistentFlags().Duration(
+		flagHttpReadTimeout,
+		utils.DefaultHttpOptions.ReadTimeout,
+		"timeout to wait for HTTP response headers or for the next chunk of data",
+	)
+	rootCmd.PersistentFlags().Int(
+		flagHttpRetries,
+		utils.DefaultHttpOptions.Retries,
+		"retry HTTP requests failed due to transient errors, timeouts or 5xx/429 responses (with exponential backoff)",
+	)
+	rootCmd.PersistentFlags().String(
+		flagHttpCaBundle,
+		"",
+		"PEM file with extra CA certificates to trust (default - just system ones)",
+	)
+	rootCmd.PersistentFlags().Bool(
+		flagOffline,
+		false,
+		"never fetch remote files and fail if something is not cached (default - $"+envOffline+" or false)",
 	)
 
 	// Flags that change behavior
-	rootCmd.Flags().StringVarP(
+	rootCmd.PersistentFlags().StringVarP(
 		&optionUnmount,
 		flagUnmount,
 		"u",
@@ -265,12 +668,31 @@ func init() {
 		"force unmount dir (just unmount the given dir and exit, all other flags and arguments ignored)",
 	)
 
-	_ = viper.BindPFlag(flagIndex, rootCmd.Flags().Lookup(flagIndex))
-	_ = viper.BindPFlag(flagExtensions, rootCmd.Flags().Lookup(flagExtensions))
-	_ = viper.BindPFlag(flagCache, rootCmd.Flags().Lookup(flagCache))
-	_ = viper.BindPFlag(flagRefresh, rootCmd.Flags().Lookup(flagRefresh))
-	_ = viper.BindPFlag(flagTerraform, rootCmd.Flags().Lookup(flagTerraform))
-	_ = viper.BindPFlag(flagTerragrunt, rootCmd.Flags().Lookup(flagTerragrunt))
+	_ = viper.BindPFlag(flagIndex, rootCmd.PersistentFlags().Lookup(flagIndex))
+	_ = viper.BindPFlag(flagIndexMerge, rootCmd.PersistentFlags().Lookup(flagIndexMerge))
+	_ = viper.BindPFlag(flagExtensions, rootCmd.PersistentFlags().Lookup(flagExtensions))
+	_ = viper.BindPFlag(flagCache, rootCmd.PersistentFlags().Lookup(flagCache))
+	_ = viper.BindPFlag(flagRefresh, rootCmd.PersistentFlags().Lookup(flagRefresh))
+	_ = viper.BindPFlag(flagDownloads, rootCmd.PersistentFlags().Lookup(flagDownloads))
+	_ = viper.BindPFlag(flagMode, rootCmd.PersistentFlags().Lookup(flagMode))
+	_ = viper.BindPFlag(flagCacheMaxSize, rootCmd.PersistentFlags().Lookup(flagCacheMaxSize))
+	_ = viper.BindPFlag(flagLock, rootCmd.PersistentFlags().Lookup(flagLock))
+	_ = viper.BindPFlag(flagPinLatest, rootCmd.PersistentFlags().Lookup(flagPinLatest))
+	_ = viper.BindPFlag(flagIndexSigned, rootCmd.PersistentFlags().Lookup(flagIndexSigned))
+	_ = viper.BindPFlag(flagTerraform, rootCmd.PersistentFlags().Lookup(flagTerraform))
+	_ = viper.BindPFlag(flagTerraformPrerelease, rootCmd.PersistentFlags().Lookup(flagTerraformPrerelease))
+	_ = viper.BindPFlag(flagTerragrunt, rootCmd.PersistentFlags().Lookup(flagTerragrunt))
+	_ = viper.BindPFlag(flagTerraformReleases, rootCmd.PersistentFlags().Lookup(flagTerraformReleases))
+	_ = viper.BindPFlag(flagTerraformDownloads, rootCmd.PersistentFlags().Lookup(flagTerraformDownloads))
+	_ = viper.BindPFlag(flagTerragruntReleases, rootCmd.PersistentFlags().Lookup(flagTerragruntReleases))
+	_ = viper.BindPFlag(flagTerragruntDownloads, rootCmd.PersistentFlags().Lookup(flagTerragruntDownloads))
+	_ = viper.BindPFlag(flagInsecure, rootCmd.PersistentFlags().Lookup(flagInsecure))
+	_ = viper.BindPFlag(flagHttpConnectTimeout, rootCmd.PersistentFlags().Lookup(flagHttpConnectTimeout))
+	_ = viper.BindPFlag(flagHttpReadTimeout, rootCmd.PersistentFlags().Lookup(flagHttpReadTimeout))
+	_ = viper.BindPFlag(flagHttpRetries, rootCmd.PersistentFlags().Lookup(flagHttpRetries))
+	_ = viper.BindPFlag(flagHttpCaBundle, rootCmd.PersistentFlags().Lookup(flagHttpCaBundle))
+	_ = viper.BindPFlag(flagOffline, rootCmd.PersistentFlags().Lookup(flagOffline))
+	_ = viper.BindEnv(flagOffline, envOffline)
 }
 
 func initConfig() {
@@ -303,4 +725,32 @@ func initConfig() {
 			os.Exit(1)
 		}
 	}
+
+	// applies to everything Para fetches so it's configured before any command runs
+	err := utils.SetHttpOptions(utils.HttpOptions{
+		ConnectTimeout: viper.GetDuration(flagHttpConnectTimeout),
+		ReadTimeout:    viper.GetDuration(flagHttpReadTimeout),
+		Retries:        viper.GetInt(flagHttpRetries),
+		CaBundle:       viper.GetString(flagHttpCaBundle),
+		Offline:        viper.GetBool(flagOffline),
+	})
+	if err != nil {
+		fmt.Printf("* Error: cannot configure HTTP client: %s\n", err)
+		os.Exit(1)
+	}
+	if viper.GetBool(flagOffline) {
+		fmt.Println("- Offline Mode: only cached indices, plugins and tools are used")
+	}
+	credentials, err := readHttpCredentials()
+	var warning string
+	if err == nil {
+		warning, err = utils.SetHttpCredentials(credentials)
+	}
+	if err != nil {
+		fmt.Printf("* Error: cannot configure HTTP credentials: %s\n", err)
+		os.Exit(1)
+	}
+	if warning != "" {
+		fmt.Printf("* Warning: %s\n", warning)
+	}
 }
@@ -37,22 +37,36 @@ func DigestVerify(path, digest string) error {
 	alg := tokens[0]
 	expected := tokens[1]
 
-	sink := supportedHashes[alg]()
+	actualDigest, err := DigestCompute(path, alg)
+	if err != nil {
+		return err
+	}
+
+	actual := actualDigest[len(alg)+1:]
+	if actual != expected {
+		return fmt.Errorf("actual %s hash value of %s does not match expected of %s", alg, actual, expected)
+	}
+	return nil
+}
+
+// DigestCompute returns a digest of the file in the form of '<alg>:<hash>'
+func DigestCompute(path, alg string) (string, error) {
+	newSink, ok := supportedHashes[alg]
+	if !ok {
+		return "", fmt.Errorf("unsupported hash algorithm: '%s'", alg)
+	}
+	sink := newSink()
 
 	source, err := os.Open(path)
 	if err != nil {
-		return err
+		return "", err
 	}
 	defer func() { _ = source.Close() }()
 
 	_, err = io.Copy(sink, source)
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	actual := fmt.Sprintf("%x", sink.Sum(nil))
-	if actual != expected {
-		return fmt.Errorf("actual %s hash value of %s does not match expected of %s", alg, actual, expected)
-	}
-	return nil
+	return fmt.Sprintf("%s:%x", alg, sink.Sum(nil)), nil
 }
@@ -4,13 +4,10 @@ import (
 	"fmt"
 	"github.com/gobwas/glob"
 	"github.com/mholt/archiver"
-	"github.com/mitchellh/go-homedir"
 	"io"
 	"io/ioutil"
-	"net/http"
 	"os"
 	"path/filepath"
-	"strings"
 	"time"
 )
 
@@ -18,58 +15,60 @@ type DownloadableFile struct {
 	Url            string
 	Digest         string
 	ExtractPattern string // must be set to extract archives
+	PartialDir     string // where partial downloads are kept to be resumed later (remote files are not resumed if empty)
+
+	// Progress is called as a resumable download grows with the file being written and its size so far so that the
+	// data can be read before the download is complete (see Streamable) - the size goes back to 0 if it starts over
+	Progress func(part *os.File, size int64)
+}
+
+// Streamable tells whether the data can be read while it's being downloaded: it must be a remote file that is resumed
+// and not an archive to be extracted
+func (d DownloadableFile) Streamable() bool {
+	return UrlIsRemote(d.Url) && d.PartialDir != "" && !d.IsArchive()
+}
+
+// IsArchive tells whether the file would be extracted - only if it's requested and the compression is supported
+func (d DownloadableFile) IsArchive() bool {
+	_, err := archiver.ByExtension(filepath.Base(d.Url))
+	return err == nil && d.ExtractPattern != ""
 }
 
 func (d DownloadableFile) Open() (io.ReadCloser, error) {
+	var rawData *os.File
 	var err error
-	var reader io.ReadCloser
 
-	if UrlIsRemote(d.Url) {
-		resp, err := http.Get(d.Url)
+	if UrlIsRemote(d.Url) && d.PartialDir != "" {
+		// Download resuming partial download if there is one
+		var progress func(*os.File, int64)
+		if !d.IsArchive() {
+			progress = d.Progress // compressed data is of no use to anyone
+		}
+		rawData, err = httpDownloadResumable(d.Url, d.PartialDir, progress)
 		if err != nil {
 			return nil, err
-		} else if resp.StatusCode != 200 {
-			return nil, fmt.Errorf(
-				"non-200 response while fetching '%s': %s",
-				d.Url, http.StatusText(resp.StatusCode),
-			)
 		}
-		reader = resp.Body
 	} else {
-		path := d.Url
-
-		if strings.HasPrefix(d.Url, schemaFile) {
-			path = d.Url[len(schemaFile):]
-		}
-
-		expandedPath, err := homedir.Expand(path)
+		// Create temp file to fetch data into
+		rawData, err = ioutil.TempFile("", fmt.Sprintf("para.raw.*.%s", filepath.Base(d.Url)))
 		if err != nil {
 			return nil, err
 		}
 
-		reader, err = os.Open(expandedPath)
+		// Download
+		if UrlIsRemote(d.Url) {
+			err = httpDownload(d.Url, rawData)
+		} else {
+			err = copyLocalFile(d.Url, rawData)
+		}
 		if err != nil {
+			_ = rawData.Close()
+			_ = os.Remove(rawData.Name())
+
 			return nil, err
 		}
 	}
 
-	// Create temp file to fetch data into
-	rawData, err := ioutil.TempFile("", fmt.Sprintf("para.raw.*.%s", filepath.Base(d.Url)))
-	if err != nil {
-		return nil, err
-	}
-
-	// Download
-	_, err = io.Copy(rawData, reader)
-	_ = reader.Close() // we have to close it regardless of the error status
-
-	if err != nil {
-		_ = rawData.Close()
-		_ = os.Remove(rawData.Name())
-
-		return nil, err
-	}
-
 	// Rewind to the beginning of the file
 	_, err = rawData.Seek(0, 0)
 	if err != nil {
@@ -90,8 +89,7 @@ func (d DownloadableFile) Open() (io.ReadCloser, error) {
 	}
 
 	// Extract if supported and requested
-	_, err = archiver.ByExtension(rawData.Name())
-	if err != nil || d.ExtractPattern == "" {
+	if !d.IsArchive() {
 		// not compressed or not supported or extraction not requested - treat it as raw data
 		return VolatileTempFile{file: rawData}, nil
 	}
@@ -143,6 +141,20 @@ func (d DownloadableFile) Open() (io.ReadCloser, error) {
 	return VolatileTempFile{file: uncompressedData}, nil
 }
 
+func copyLocalFile(url string, writer io.Writer) error {
+	expandedPath, err := UrlToPath(url)
+	if err != nil {
+		return err
+	}
+	reader, err := os.Open(expandedPath)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(writer, reader)
+	_ = reader.Close() // we have to close it regardless of the error status
+	return err
+}
+
 func (d DownloadableFile) SaveTo(path string) error {
 	// Get the data
 	pluginData, err := d.Open()
@@ -151,21 +163,15 @@ func (d DownloadableFile) SaveTo(path string) error {
 	}
 	defer func() { _ = pluginData.Close() }()
 
-	// Create the file
-	err = os.MkdirAll(filepath.Dir(path), 0755)
+	// Create a temp file next to the destination so that it's never seen partially written (e.g. by other processes)
+	out, err := createTempNextTo(path)
 	if err != nil {
 		return err
 	}
-	out, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
-	if err != nil {
-		return err
-	}
-	defer func() { _ = out.Close() }()
-	defer func() { _ = os.Chmod(path, 0755) }()
 
-	// Write the body to file
+	// Write the body to file and move it into place
 	_, err = io.Copy(out, pluginData)
-	return err
+	return commitTempFile(out, path, 0755, err)
 }
 
 func (d DownloadableFile) ReadAll() ([]byte, error) {
@@ -189,7 +195,9 @@ func (d DownloadableFile) ReadAllWithCache(cacheDir string, refresh time.Duratio
 			cacheTimestamp = cacheMeta.ModTime()
 		}
 
-		if cacheTimestamp.Before(time.Now().Add(-refresh)) || errCacheData != nil {
+		// in offline mode the cache is used no matter how old it is
+		stale := cacheTimestamp.Before(time.Now().Add(-refresh)) && !httpOptions.Offline
+		if stale || errCacheData != nil {
 			freshData, errFreshData := d.ReadAll()
 			if errFreshData != nil {
 				if errCacheData != nil {
@@ -199,8 +207,7 @@ func (d DownloadableFile) ReadAllWithCache(cacheDir string, refresh time.Duratio
 				return cacheData, cacheTimestamp, nil
 			}
 			// we fetched fresh data - let's try to cache it but don't sweat if fail
-			_ = os.MkdirAll(cacheDir, 0755)
-			_ = ioutil.WriteFile(indexCachePath, freshData, 0644)
+			_ = WriteFileAtomic(indexCachePath, freshData, 0644)
 			return freshData, time.Now(), nil
 		} else {
 			return cacheData, cacheTimestamp, nil
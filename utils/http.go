@@ -0,0 +1,458 @@
+package utils
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// HttpCredentials are applied to every request (including redirects) whose URL matches the prefix: the scheme and the
+// host (with the port if any) must be the same and the path must be either the same or within the prefix path
+type HttpCredentials struct {
+	Prefix   string
+	Token    string // sent as a bearer token
+	Username string // sent with password via basic auth
+	Password string
+	Headers  map[string]string
+}
+
+// HttpOptions control how Para talks to remote servers
+type HttpOptions struct {
+	ConnectTimeout time.Duration // to establish a connection (including TLS handshake)
+	ReadTimeout    time.Duration // to wait for response headers or for the next chunk of the body
+	Retries        int           // on connection errors, timeouts and 5xx responses
+	CaBundle       string        // path to PEM file with extra trusted certificates (e.g. for TLS interception)
+	Offline        bool          // never fetch remote files - only cached ones can be used
+}
+
+var DefaultHttpOptions = HttpOptions{
+	ConnectTimeout: 10 * time.Second,
+	ReadTimeout:    time.Minute,
+	Retries:        3,
+}
+
+var httpRetryBackoff = time.Second // doubles with every attempt
+
+// a server asking to come back later than that is not waited for
+const httpRetryAfterMax = 5 * time.Minute
+
+// credentials are applied by the transport rather than set on requests so that they are never sent along redirects
+// to URLs they are not meant for
+var httpClient = &http.Client{Transport: &credentialsTransport{base: mustHttpTransport(DefaultHttpOptions)}}
+var httpOptions = DefaultHttpOptions
+
+var httpCredentials []scopedCredentials // sorted by prefix length so that the most specific one wins
+var netrcMachines map[string]netrcMachine
+var netrcErr error // why netrc could not be read - it only matters once a server asks for credentials
+
+type scopedCredentials struct {
+	HttpCredentials
+	scope *url.URL // parsed prefix
+}
+
+type netrcMachine struct {
+	Login    string
+	Password string
+}
+
+// SetHttpCredentials configures credentials for all HTTP requests Para makes. Credentials from ~/.netrc (or a file
+// specified by $NETRC) are used for hosts that are not matched by any of given prefixes - if it cannot be read then a
+// warning is returned (and repeated by errors of requests that are refused for lack of credentials).
+func SetHttpCredentials(credentials []HttpCredentials) (string, error) {
+	var scoped []scopedCredentials
+	for _, c := range credentials {
+		scope, err := url.Parse(c.Prefix)
+		if err != nil || !UrlIsRemote(c.Prefix) || scope.Host == "" {
+			return "", fmt.Errorf("credentials prefix '%s' must be an http(s) URL with a host", UrlRedact(c.Prefix))
+		}
+		scoped = append(scoped, scopedCredentials{HttpCredentials: c, scope: scope})
+	}
+	sort.SliceStable(scoped, func(a, b int) bool {
+		return len(scoped[a].Prefix) > len(scoped[b].Prefix)
+	})
+	httpCredentials = scoped
+
+	path := os.Getenv("NETRC")
+	if path == "" {
+		path = PathExpand("~/.netrc")
+	}
+	machines, err := parseNetrc(path)
+	netrcMachines, netrcErr = machines, nil
+	if err != nil && !os.IsNotExist(err) {
+		netrcMachines, netrcErr = nil, fmt.Errorf("cannot read credentials from '%s': %s", path, err)
+		return netrcErr.Error(), nil
+	}
+	return "", nil
+}
+
+// SetHttpOptions configures the client used for all HTTP requests Para makes
+func SetHttpOptions(options HttpOptions) error {
+	transport, err := newHttpTransport(options)
+	if err != nil {
+		return err
+	}
+	httpClient.Transport = &credentialsTransport{base: transport}
+	httpOptions = options
+	return nil
+}
+
+func newHttpTransport(options HttpOptions) (*http.Transport, error) {
+	dialer := &net.Dialer{Timeout: options.ConnectTimeout, KeepAlive: 30 * time.Second}
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment, // HTTPS_PROXY, HTTP_PROXY & NO_PROXY
+		DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+			conn, err := dialer.DialContext(ctx, network, address)
+			if err != nil || options.ReadTimeout <= 0 {
+				return conn, err
+			}
+			return &readTimeoutConn{Conn: conn, timeout: options.ReadTimeout}, nil
+		},
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   options.ConnectTimeout,
+		ResponseHeaderTimeout: options.ReadTimeout,
+		ExpectContinueTimeout: time.Second,
+	}
+
+	if options.CaBundle != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		path, err := UrlToPath(options.CaBundle)
+		if err != nil {
+			return nil, err
+		}
+		bundle, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read CA bundle: %s", err)
+		}
+		if !pool.AppendCertsFromPEM(bundle) {
+			return nil, fmt.Errorf("no PEM certificates found in CA bundle '%s'", options.CaBundle)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	return transport, nil
+}
+
+func mustHttpTransport(options HttpOptions) *http.Transport {
+	transport, err := newHttpTransport(options)
+	if err != nil {
+		panic(err)
+	}
+	return transport
+}
+
+// readTimeoutConn fails reads that don't receive anything for too long - unlike an overall timeout it doesn't limit
+// how long large downloads may take
+type readTimeoutConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *readTimeoutConn) Read(b []byte) (int, error) {
+	err := c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+	if err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(b)
+}
+
+type httpStatusError struct {
+	Url        string
+	StatusCode int
+	RetryAfter time.Duration // as asked by the server, 0 if it didn't
+	NetrcErr   error         // why credentials from netrc were not there for a request that needed them
+}
+
+func newHttpStatusError(address string, resp *http.Response) *httpStatusError {
+	statusErr := &httpStatusError{
+		Url: address, StatusCode: resp.StatusCode, RetryAfter: parseRetryAfter(resp.Header, time.Now()),
+	}
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		if target, err := url.Parse(address); err == nil && findScopedCredentials(target) == nil {
+			statusErr.NetrcErr = netrcErr
+		}
+	}
+	return statusErr
+}
+
+// parseRetryAfter reads the Retry-After header which is either a number of seconds or an HTTP date
+func parseRetryAfter(header http.Header, now time.Time) time.Duration {
+	value := strings.TrimSpace(header.Get("Retry-After"))
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.ParseUint(value, 10, 32); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
+}
+
+func (e *httpStatusError) Error() string {
+	message := fmt.Sprintf("non-200 response while fetching '%s': %s", UrlRedact(e.Url), http.StatusText(e.StatusCode))
+	if e.NetrcErr != nil {
+		message += fmt.Sprintf(" (%s)", e.NetrcErr)
+	}
+	return message
+}
+
+// OfflineError means that a remote file is needed but it cannot be fetched in offline mode as it's not cached
+type OfflineError struct {
+	Url string
+}
+
+func (e *OfflineError) Error() string {
+	return fmt.Sprintf("'%s' is not cached and cannot be fetched in offline mode", UrlRedact(e.Url))
+}
+
+// IsNotFound tells whether the error means that the file is not there (as opposed to failing to fetch it)
+func IsNotFound(err error) bool {
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode == http.StatusNotFound || statusErr.StatusCode == http.StatusGone
+	}
+	return errors.Is(err, os.ErrNotExist)
+}
+
+// httpDownload fetches the URL into the file (from scratch on every attempt)
+func httpDownload(url string, file *os.File) error {
+	return httpRetry(url, func() error {
+		err := file.Truncate(0)
+		if err != nil {
+			return err
+		}
+		_, err = file.Seek(0, 0)
+		if err != nil {
+			return err
+		}
+		return httpFetch(url, file)
+	})
+}
+
+// httpRetry retries the fetch with exponential backoff on errors that may be transient
+func httpRetry(url string, fetch func() error) error {
+	if httpOptions.Offline {
+		return &OfflineError{Url: url} // fail fast rather than wait for timeouts
+	}
+	backoff := httpRetryBackoff
+	for attempt := 1; ; attempt++ {
+		err := fetch()
+		if err == nil {
+			return nil
+		}
+		delay := backoff
+		var statusErr *httpStatusError
+		if errors.As(err, &statusErr) && statusErr.RetryAfter > delay {
+			delay = statusErr.RetryAfter
+		}
+		if attempt > httpOptions.Retries || !isRetryable(err) || delay > httpRetryAfterMax {
+			if attempt > 1 {
+				return fmt.Errorf("%w (gave up on '%s' after %d attempts)", err, UrlRedact(url), attempt)
+			}
+			return err
+		}
+		time.Sleep(delay)
+		backoff *= 2
+	}
+}
+
+func httpFetch(url string, writer io.Writer) error {
+	resp, err := httpClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return newHttpStatusError(url, resp)
+	}
+	_, err = io.Copy(writer, resp.Body)
+	return err
+}
+
+// isRetryable tells whether the error may go away on its own - only known transient errors are retried: timeouts,
+// refused, reset or prematurely closed connections, temporary DNS failures, truncated responses, server errors and
+// rate limiting. Anything else (client errors, untrusted certificates, local I/O errors, etc.) is not.
+func isRetryable(err error) bool {
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500 || statusErr.StatusCode == http.StatusTooManyRequests
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTimeout || dnsErr.IsTemporary
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	for _, transient := range []error{
+		syscall.ECONNREFUSED, syscall.ECONNRESET, syscall.ECONNABORTED, syscall.EPIPE,
+		io.ErrUnexpectedEOF, io.EOF, // connection closed before the whole response was received
+		errPartialDiscarded,
+	} {
+		if errors.Is(err, transient) {
+			return true
+		}
+	}
+	return false
+}
+
+type credentialsTransport struct {
+	base http.RoundTripper
+}
+
+func (t *credentialsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Authorization") != "" {
+		return t.base.RoundTrip(req)
+	}
+	authenticated := req.Clone(req.Context())
+	applyCredentials(authenticated)
+	return t.base.RoundTrip(authenticated)
+}
+
+// findScopedCredentials returns the most specific configured credentials for the URL (if any)
+func findScopedCredentials(target *url.URL) *scopedCredentials {
+	for idx := range httpCredentials {
+		if credentialsMatch(httpCredentials[idx].scope, target) {
+			return &httpCredentials[idx]
+		}
+	}
+	return nil
+}
+
+func applyCredentials(req *http.Request) {
+	if credentials := findScopedCredentials(req.URL); credentials != nil {
+		switch {
+		case credentials.Token != "":
+			req.Header.Set("Authorization", "Bearer "+credentials.Token)
+		case credentials.Username != "":
+			req.SetBasicAuth(credentials.Username, credentials.Password)
+		}
+		for name, value := range credentials.Headers {
+			req.Header.Set(name, value)
+		}
+		return
+	}
+
+	machine, ok := netrcMachines[req.URL.Hostname()]
+	if !ok {
+		machine, ok = netrcMachines[""] // default
+	}
+	if ok && machine.Login != "" {
+		req.SetBasicAuth(machine.Login, machine.Password)
+	}
+}
+
+// credentialsMatch tells whether the URL is within the scope: a plain string prefix would let credentials for
+// https://example.com leak to https://example.com.evil.org (or /repo to /repo-other) - default ports are implied
+func credentialsMatch(scope, target *url.URL) bool {
+	if !strings.EqualFold(scope.Scheme, target.Scheme) || !strings.EqualFold(urlHostPort(scope), urlHostPort(target)) {
+		return false
+	}
+	scopePath := strings.TrimSuffix(scope.EscapedPath(), "/")
+	targetPath := target.EscapedPath()
+	return scopePath == "" || targetPath == scopePath || strings.HasPrefix(targetPath, scopePath+"/")
+}
+
+// urlHostPort returns host:port of the URL with the default port of its scheme if it's not given explicitly
+func urlHostPort(address *url.URL) string {
+	port := address.Port()
+	if port == "" {
+		port = map[string]string{"http": "80", "https": "443"}[strings.ToLower(address.Scheme)]
+	}
+	return net.JoinHostPort(address.Hostname(), port)
+}
+
+// parseNetrc reads machines (and the default one under an empty name) from a netrc file, macros are skipped
+func parseNetrc(path string) (map[string]netrcMachine, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = file.Close() }()
+
+	machines := make(map[string]netrcMachine)
+	var current *netrcMachine
+	var currentName string
+	save := func() {
+		if current != nil {
+			if _, seen := machines[currentName]; !seen {
+				machines[currentName] = *current // the first match wins, same as in curl
+			}
+		}
+	}
+
+	scanner := bufio.NewScanner(file)
+	inMacro := false
+	for scanner.Scan() {
+		line := scanner.Text()
+		if inMacro {
+			inMacro = strings.TrimSpace(line) != "" // a macro ends with an empty line
+			continue
+		}
+		fields := strings.Fields(line)
+		for idx := 0; idx < len(fields); idx++ {
+			next := func() string {
+				idx++
+				if idx < len(fields) {
+					return fields[idx]
+				}
+				return ""
+			}
+			switch fields[idx] {
+			case "machine":
+				save()
+				current, currentName = &netrcMachine{}, next()
+			case "default":
+				save()
+				current, currentName = &netrcMachine{}, ""
+			case "login":
+				if current != nil {
+					current.Login = next()
+				}
+			case "password":
+				if current != nil {
+					current.Password = next()
+				}
+			case "account":
+				next()
+			case "macdef":
+				inMacro = true
+				idx = len(fields)
+			}
+		}
+	}
+	save()
+	return machines, scanner.Err()
+}
+
+// UrlRedact hides password from the URL (if there is one) so that the URL can be shown to the user
+func UrlRedact(address string) string {
+	parsed, err := url.Parse(address)
+	if err != nil || parsed.User == nil {
+		return address
+	}
+	if _, hasPassword := parsed.User.Password(); hasPassword {
+		parsed.User = url.UserPassword(parsed.User.Username(), "xxxxx")
+	}
+	return parsed.String()
+}
@@ -0,0 +1,297 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestApplyCredentials(t *testing.T) {
+	netrc := filepath.Join(t.TempDir(), ".netrc")
+	err := ioutil.WriteFile(netrc, []byte("machine netrc.example.com login alice password secret\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(value string, set bool) {
+		if set {
+			_ = os.Setenv("NETRC", value)
+		} else {
+			_ = os.Unsetenv("NETRC")
+		}
+		_, _ = SetHttpCredentials(nil)
+	}(os.LookupEnv("NETRC"))
+	_ = os.Setenv("NETRC", netrc)
+
+	_, err = SetHttpCredentials([]HttpCredentials{
+		{Prefix: "https://example.com", Token: "host"},
+		{Prefix: "https://example.com/repo", Token: "repo"},
+		{Prefix: "https://example.com/repo/nested/", Token: "nested"},
+		{Prefix: "https://example.com:8443/", Token: "port"},
+		{Prefix: "http://plain.example.com/", Username: "bob", Password: "pass"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		url      string
+		expected string // Authorization header
+	}{
+		{"https://example.com", "Bearer host"},
+		{"https://example.com/", "Bearer host"},
+		{"https://EXAMPLE.com/other", "Bearer host"},
+		{"https://example.com/repo", "Bearer repo"},
+		{"https://example.com/repo/a.zip", "Bearer repo"},
+		{"https://example.com/repo?ref=main", "Bearer repo"},
+		{"https://example.com/repo-other/a.zip", "Bearer host"},
+		{"https://example.com/repo/nested", "Bearer nested"},
+		{"https://example.com/repo/nested/a.zip", "Bearer nested"},
+		{"https://example.com/repo/nestedother", "Bearer repo"},
+		{"https://example.com:8443/a.zip", "Bearer port"},
+		{"https://example.com:443/repo/a.zip", "Bearer repo"},
+		{"https://example.com:80/repo/a.zip", ""},
+		{"http://plain.example.com:80/a.zip", "Basic Ym9iOnBhc3M="},
+		{"http://example.com/repo/a.zip", ""},
+		{"https://example.com.evil.org/repo/a.zip", ""},
+		{"https://evil.org/https://example.com/repo", ""},
+		{"https://example.com@evil.org/repo", ""},
+		{"https://plain.example.com/a.zip", ""},
+		{"http://plain.example.com/a.zip", "Basic Ym9iOnBhc3M="},
+		{"https://netrc.example.com/a.zip", "Basic YWxpY2U6c2VjcmV0"},
+	} {
+		t.Run(tc.url, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, tc.url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			applyCredentials(req)
+			if actual := req.Header.Get("Authorization"); actual != tc.expected {
+				t.Fatalf("expected '%s', got '%s'", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestSetHttpCredentialsMalformed(t *testing.T) {
+	defer func() { _, _ = SetHttpCredentials(nil) }()
+	for _, prefix := range []string{"example.com/repo", "ftp://example.com/", "https:///repo", "https://exa mple.com"} {
+		if _, err := SetHttpCredentials([]HttpCredentials{{Prefix: prefix, Token: "token"}}); err == nil {
+			t.Errorf("expected prefix '%s' to be rejected", prefix)
+		}
+	}
+}
+
+// An unreadable netrc is only worth mentioning when a server asks for credentials that it could have provided
+func TestNetrcUnreadable(t *testing.T) {
+	defer func(value string, set bool) {
+		if set {
+			_ = os.Setenv("NETRC", value)
+		} else {
+			_ = os.Unsetenv("NETRC")
+		}
+		_, _ = SetHttpCredentials(nil)
+	}(os.LookupEnv("NETRC"))
+	_ = os.Setenv("NETRC", t.TempDir()) // a dir cannot be read as a file
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status, _ := strconv.Atoi(filepath.Base(r.URL.Path))
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	warning, err := SetHttpCredentials([]HttpCredentials{{Prefix: server.URL + "/configured", Token: "token"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(warning, "cannot read credentials from") {
+		t.Fatalf("expected a warning about netrc, got '%s'", warning)
+	}
+
+	for _, tc := range []struct {
+		path      string
+		mentioned bool
+	}{
+		{"/401", true},
+		{"/403", true},
+		{"/404", false},
+		{"/configured/403", false},
+	} {
+		t.Run(tc.path, func(t *testing.T) {
+			err := httpFetch(server.URL+tc.path, ioutil.Discard)
+			if err == nil {
+				t.Fatal("expected the request to fail")
+			}
+			if mentioned := strings.Contains(err.Error(), warning); mentioned != tc.mentioned {
+				t.Fatalf("expected netrc to be mentioned: %t, got %s", tc.mentioned, err)
+			}
+		})
+	}
+}
+
+func TestParseNetrc(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		content  string
+		expected map[string]netrcMachine
+	}{
+		{"one per line", "machine a.com login alice password secret\nmachine b.com login bob password hunter2\n",
+			map[string]netrcMachine{"a.com": {"alice", "secret"}, "b.com": {"bob", "hunter2"}}},
+		{"multi line", "machine a.com\n  login alice\n  password secret\n", map[string]netrcMachine{
+			"a.com": {"alice", "secret"},
+		}},
+		{"default", "machine a.com login alice password secret\ndefault login anonymous password guest\n",
+			map[string]netrcMachine{"a.com": {"alice", "secret"}, "": {"anonymous", "guest"}}},
+		{"first wins", "machine a.com login alice password one\nmachine a.com login bob password two\n",
+			map[string]netrcMachine{"a.com": {"alice", "one"}}},
+		{"account", "machine a.com login alice account acme password secret\n", map[string]netrcMachine{
+			"a.com": {"alice", "secret"},
+		}},
+		{"macro", "macdef init\ncd /pub\nmachine evil.com login x password y\n\nmachine a.com login alice password s\n",
+			map[string]netrcMachine{"a.com": {"alice", "s"}}},
+		{"login only", "machine a.com login alice\n", map[string]netrcMachine{"a.com": {"alice", ""}}},
+		{"outside of machine", "login alice password secret\n", map[string]netrcMachine{}},
+		{"empty", "", map[string]netrcMachine{}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), ".netrc")
+			if err := ioutil.WriteFile(path, []byte(tc.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			machines, err := parseNetrc(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(machines, tc.expected) {
+				t.Fatalf("expected %v, got %v", tc.expected, machines)
+			}
+		})
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		err       error
+		retryable bool
+	}{
+		{"server error", &httpStatusError{StatusCode: http.StatusBadGateway}, true},
+		{"rate limited", &httpStatusError{StatusCode: http.StatusTooManyRequests}, true},
+		{"not found", &httpStatusError{StatusCode: http.StatusNotFound}, false},
+		{"forbidden", fmt.Errorf("wrapped: %w", &httpStatusError{StatusCode: http.StatusForbidden}), false},
+		{"timeout", &url.Error{Op: "Get", URL: "https://example.com", Err: os.ErrDeadlineExceeded}, true},
+		{"refused", &url.Error{Op: "Get", URL: "https://example.com", Err: &net.OpError{
+			Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED),
+		}}, true},
+		{"reset", &net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.ECONNRESET)}, true},
+		{"truncated", io.ErrUnexpectedEOF, true},
+		{"closed early", &url.Error{Op: "Get", URL: "https://example.com", Err: io.EOF}, true},
+		{"partial discarded", fmt.Errorf("cannot resume: %w", errPartialDiscarded), true},
+		{"temporary dns", &net.DNSError{Err: "server misbehaving", Name: "example.com", IsTemporary: true}, true},
+		{"no such host", &url.Error{Op: "Get", URL: "https://example.com", Err: &net.DNSError{
+			Err: "no such host", Name: "example.com", IsNotFound: true,
+		}}, false},
+		{"untrusted certificate", &url.Error{Op: "Get", URL: "https://example.com", Err: x509.UnknownAuthorityError{}},
+			false},
+		{"disk full", &os.PathError{Op: "write", Path: "/tmp/x", Err: syscall.ENOSPC}, false},
+		{"unknown", errors.New("something else"), false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if isRetryable(tc.err) != tc.retryable {
+				t.Fatalf("expected retryable to be %v for %v", tc.retryable, tc.err)
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		value    string
+		expected time.Duration
+	}{
+		{"", 0},
+		{"120", 2 * time.Minute},
+		{" 5 ", 5 * time.Second},
+		{"Wed, 01 Jan 2020 00:01:30 GMT", 90 * time.Second},
+		{"Tue, 31 Dec 2019 23:59:00 GMT", 0},
+		{"-5", 0},
+		{"soon", 0},
+	} {
+		t.Run(tc.value, func(t *testing.T) {
+			header := http.Header{}
+			header.Set("Retry-After", tc.value)
+			if actual := parseRetryAfter(header, now); actual != tc.expected {
+				t.Fatalf("expected %s, got %s", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestHttpRetry(t *testing.T) {
+	defer func(backoff time.Duration) { httpRetryBackoff = backoff }(httpRetryBackoff)
+	httpRetryBackoff = time.Millisecond
+
+	for _, tc := range []struct {
+		name      string
+		responses []string // status codes with optional Retry-After
+		attempts  int
+		wait      time.Duration // at least
+		err       bool
+	}{
+		{"ok", []string{"200"}, 1, 0, false},
+		{"retried", []string{"502", "503", "200"}, 3, 0, false},
+		{"retry after", []string{"429 1", "200"}, 2, time.Second, false},
+		{"retry after too long", []string{"503 3600", "200"}, 1, 0, true},
+		{"not retried", []string{"403", "200"}, 1, 0, true},
+		{"gave up", []string{"500", "500", "500", "500", "200"}, 4, 0, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			attempts := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				response := strings.Fields(tc.responses[attempts])
+				attempts++
+				if len(response) > 1 {
+					w.Header().Set("Retry-After", response[1])
+				}
+				status, _ := strconv.Atoi(response[0])
+				w.WriteHeader(status)
+				_, _ = w.Write([]byte("content"))
+			}))
+			defer server.Close()
+
+			var buffer bytes.Buffer
+			started := time.Now()
+			err := httpRetry(server.URL, func() error {
+				buffer.Reset()
+				return httpFetch(server.URL, &buffer)
+			})
+			if (err != nil) != tc.err {
+				t.Fatalf("unexpected outcome: %v", err)
+			}
+			if attempts != tc.attempts {
+				t.Fatalf("expected %d attempts, got %d", tc.attempts, attempts)
+			}
+			if elapsed := time.Since(started); elapsed < tc.wait {
+				t.Fatalf("expected to wait at least %s, waited %s", tc.wait, elapsed)
+			}
+			if err == nil && buffer.String() != "content" {
+				t.Fatalf("unexpected content '%s'", buffer.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+const lockSuffix = ".lock"
+
+// CacheLock is an advisory lock on a cache entry that is respected by all processes (and goroutines) sharing the cache
+type CacheLock struct {
+	file *os.File
+}
+
+// LockCacheEntry blocks until nobody else holds a lock on the entry at the path and locks it. Lock files are kept next
+// to entries and are never removed (but by 'para cache purge') - otherwise a process waiting on a removed lock file
+// and one creating a new one at the same path would both get the lock.
+func LockCacheEntry(path string) (*CacheLock, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path+lockSuffix, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX)
+		if err != syscall.EINTR {
+			break
+		}
+	}
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return &CacheLock{file: file}, nil
+}
+
+func (l *CacheLock) Unlock() error {
+	err := syscall.Flock(int(l.file.Fd()), syscall.LOCK_UN)
+	errClose := l.file.Close() // releases the lock anyway
+	if err != nil {
+		return err
+	}
+	return errClose
+}
+
+// WriteFileAtomic writes data to a temp file next to the path and renames it into place so that nobody ever sees a
+// partially written file
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	temp, err := createTempNextTo(path)
+	if err != nil {
+		return err
+	}
+	_, err = temp.Write(data)
+	return commitTempFile(temp, path, perm, err)
+}
+
+func createTempNextTo(path string) (*os.File, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+}
+
+// commitTempFile renames the temp file into place unless writing it failed - the temp file is removed either way
+func commitTempFile(temp *os.File, path string, perm os.FileMode, errWrite error) error {
+	errClose := temp.Close()
+	if errWrite == nil {
+		errWrite = errClose
+	}
+	if errWrite == nil {
+		errWrite = os.Chmod(temp.Name(), perm)
+	}
+	if errWrite == nil {
+		errWrite = os.Rename(temp.Name(), path)
+	}
+	if errWrite != nil {
+		_ = os.Remove(temp.Name())
+	}
+	return errWrite
+}
+
+// AtomicFile is written next to the path and only shows up at the path once it's committed
+type AtomicFile struct {
+	*os.File
+	path string
+}
+
+func CreateFileAtomic(path string) (*AtomicFile, error) {
+	temp, err := createTempNextTo(path)
+	if err != nil {
+		return nil, err
+	}
+	return &AtomicFile{File: temp, path: path}, nil
+}
+
+// Commit renames the file into place unless writing it failed (as reported by errWrite) - it's removed in that case
+func (f *AtomicFile) Commit(perm os.FileMode, errWrite error) error {
+	return commitTempFile(f.File, f.path, perm, errWrite)
+}
@@ -0,0 +1,136 @@
+package utils
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLockCacheEntry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "plugins", "foo")
+	lock, err := LockCacheEntry(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acquired := make(chan *CacheLock)
+	go func() {
+		other, err := LockCacheEntry(path)
+		if err != nil {
+			t.Error(err)
+		}
+		acquired <- other
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("expected the entry to stay locked until unlocked")
+	case <-time.After(100 * time.Millisecond):
+	}
+	err = lock.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case other := <-acquired:
+		if other != nil {
+			_ = other.Unlock()
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the entry to be locked once unlocked by the other holder")
+	}
+
+	if !PathExists(path + lockSuffix) {
+		t.Errorf("expected lock file '%s' to be kept", path+lockSuffix)
+	}
+	if PathExists(path) {
+		t.Errorf("expected no entry to be created at '%s'", path)
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "sub", "file")
+	for _, content := range []string{"first", "second"} {
+		err := WriteFileAtomic(path, []byte(content), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(raw) != content {
+			t.Errorf("expected '%s', got '%s'", content, raw)
+		}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("expected mode %v, got %v", os.FileMode(0600), info.Mode().Perm())
+	}
+	expectOnlyFile(t, filepath.Dir(path), "file")
+}
+
+func TestAtomicFileCommit(t *testing.T) {
+	cases := []struct {
+		name     string
+		errWrite error
+	}{
+		{name: "written"},
+		{name: "failed", errWrite: errors.New("write failed")},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "file")
+			file, err := CreateFileAtomic(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = file.WriteString("content")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if PathExists(path) {
+				t.Error("expected the file not to show up before it's committed")
+			}
+
+			err = file.Commit(0644, c.errWrite)
+			if err != c.errWrite {
+				t.Fatalf("expected error %v, got %v", c.errWrite, err)
+			}
+			if c.errWrite != nil {
+				expectOnlyFile(t, dir, "")
+				return
+			}
+			raw, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(raw) != "content" {
+				t.Errorf("expected 'content', got '%s'", raw)
+			}
+			expectOnlyFile(t, dir, "file")
+		})
+	}
+}
+
+// expectOnlyFile checks that no temp files are left in the dir but the given one (if any)
+func expectOnlyFile(t *testing.T, dir, name string) {
+	t.Helper()
+	children, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, child := range children {
+		if child.Name() != name {
+			t.Errorf("expected no leftovers, got '%s'", child.Name())
+		}
+	}
+}
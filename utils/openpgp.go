@@ -0,0 +1,177 @@
+package utils
+
+import (
+	"bytes"
+	"crypto"
+	"errors"
+	"fmt"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	pgperrors "github.com/ProtonMail/go-crypto/openpgp/errors"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	openpgp "github.com/ProtonMail/go-crypto/openpgp/v2"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// Detached OpenPGP signatures are verified by go-crypto - it's up to the user to decide which keys to trust but keys
+// are trusted only as far as they vouch for themselves: they must be properly self-signed (subkeys bound to their
+// primary keys), allowed to sign, not revoked and not expired by the time a signature was made. Signatures made with
+// SHA-1 are not accepted anywhere - neither for data nor for keys.
+
+const pgpArmorPrefix = "-----BEGIN PGP "
+
+// ErrPgpKeyExpired is reported when a signature was made by a trusted key after it had expired
+var ErrPgpKeyExpired = errors.New("OpenPGP key expired")
+
+var pgpRejectedHashes = map[crypto.Hash]bool{crypto.MD5: true, crypto.RIPEMD160: true, crypto.SHA1: true}
+
+var pgpConfig = &packet.Config{
+	RejectHashAlgorithms:        pgpRejectedHashes,
+	RejectMessageHashAlgorithms: pgpRejectedHashes,
+}
+
+// PgpKeyRing is a set of trusted OpenPGP public keys
+type PgpKeyRing struct {
+	entities openpgp.EntityList
+}
+
+// Add parses armored or binary public keys and adds them to the key ring unless revoked
+func (r *PgpKeyRing) Add(raw []byte) error {
+	return r.add(raw, "")
+}
+
+// AddPinned adds just the primary key with the given fingerprint (along with its subkeys) from armored or binary public
+// keys so that keys fetched from untrusted locations can be used
+func (r *PgpKeyRing) AddPinned(raw []byte, fingerprint string) error {
+	return r.add(raw, strings.ToUpper(strings.Replace(fingerprint, " ", "", -1)))
+}
+
+func (r *PgpKeyRing) add(raw []byte, fingerprint string) error {
+	data, err := pgpDearmor(raw)
+	if err != nil {
+		return err
+	}
+	entities, err := openpgp.ReadKeyRing(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("cannot read OpenPGP public keys: %s", err)
+	}
+
+	var problems []string
+	added := 0
+	for _, entity := range entities {
+		if fingerprint != "" && fmt.Sprintf("%X", entity.PrimaryKey.Fingerprint) != fingerprint {
+			continue
+		}
+		if entity.Revoked(time.Now()) {
+			problems = append(problems, fmt.Sprintf("key %X is revoked", entity.PrimaryKey.Fingerprint))
+			continue
+		}
+		r.entities = append(r.entities, entity)
+		added++
+	}
+	if added == 0 {
+		switch {
+		case len(problems) > 0:
+			return fmt.Errorf("no OpenPGP public keys that can be trusted: %s", strings.Join(problems, "; "))
+		case fingerprint != "":
+			return fmt.Errorf("no supported OpenPGP public key with fingerprint %s found", fingerprint)
+		}
+		return fmt.Errorf("no supported OpenPGP public keys found")
+	}
+	return nil
+}
+
+func (r *PgpKeyRing) IsEmpty() bool {
+	return r == nil || len(r.entities) == 0
+}
+
+// VerifyDetached verifies an armored or binary detached signature of the data and returns fingerprint of the signer
+// (either a primary key or a subkey); ErrPgpKeyExpired is wrapped if the signer had expired by the time of signing
+func (r *PgpKeyRing) VerifyDetached(data, signature []byte) (string, error) {
+	raw, err := pgpDearmor(signature)
+	if err != nil {
+		return "", err
+	}
+	signatures, err := pgpReadSignatures(raw)
+	if err != nil {
+		return "", err
+	}
+	var known []*packet.Signature
+	var ids []string
+	for _, sig := range signatures {
+		if len(r.entities.EntitiesById(*sig.IssuerKeyId)) > 0 {
+			known = append(known, sig)
+		}
+		ids = append(ids, fmt.Sprintf("%016X", *sig.IssuerKeyId))
+	}
+	if len(known) == 0 {
+		return "", fmt.Errorf("OpenPGP signature made by an untrusted key (%s)", strings.Join(ids, ", "))
+	}
+	if hash := known[0].Hash; len(known) == 1 && pgpConfig.RejectMessageHashAlgorithm(hash) {
+		return "", fmt.Errorf("OpenPGP signatures made with %s are not accepted", hash)
+	}
+
+	details, err := openpgp.VerifyDetachedSignatureReader(r.entities, bytes.NewReader(data), bytes.NewReader(raw), pgpConfig)
+	if err == nil {
+		_, err = io.Copy(ioutil.Discard, details.UnverifiedBody)
+	}
+	if err == nil {
+		err = details.SignatureError
+	}
+	switch {
+	case err == nil:
+		return fmt.Sprintf("%X", details.SignedBy.PublicKey.Fingerprint), nil
+	case errors.Is(err, pgperrors.ErrKeyExpired):
+		return "", fmt.Errorf(
+			"OpenPGP signature made on %s after the key had expired: %w", known[0].CreationTime.UTC(), ErrPgpKeyExpired,
+		)
+	case errors.Is(err, pgperrors.ErrKeyRevoked):
+		return "", fmt.Errorf("OpenPGP signature made by a revoked key")
+	}
+	var keyErr pgperrors.StructuralError
+	if errors.As(err, &keyErr) {
+		return "", fmt.Errorf("OpenPGP signature made by an untrusted key (%s: %s)", strings.Join(ids, ", "), err)
+	}
+	var sigErr pgperrors.SignatureError
+	if errors.As(err, &sigErr) {
+		return "", fmt.Errorf("OpenPGP signature does not match the data (%s)", err)
+	}
+	return "", fmt.Errorf("invalid OpenPGP signature: %s", err)
+}
+
+// pgpReadSignatures reads signatures (that name their issuers) from the packets
+func pgpReadSignatures(raw []byte) ([]*packet.Signature, error) {
+	var signatures []*packet.Signature
+	packets := packet.NewReader(bytes.NewReader(raw))
+	for {
+		p, err := packets.Next()
+		if err != nil {
+			break // trailing garbage is ignored as long as there are signatures
+		}
+		if sig, ok := p.(*packet.Signature); ok && sig.IssuerKeyId != nil {
+			signatures = append(signatures, sig)
+		}
+	}
+	if len(signatures) == 0 {
+		return nil, fmt.Errorf("no OpenPGP signature found")
+	}
+	return signatures, nil
+}
+
+// pgpDearmor decodes armored data and leaves binary data as is
+func pgpDearmor(raw []byte) ([]byte, error) {
+	if !bytes.HasPrefix(bytes.TrimSpace(raw), []byte(pgpArmorPrefix)) {
+		return raw, nil
+	}
+	block, err := armor.Decode(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode OpenPGP armor: %s", err)
+	}
+	data, err := ioutil.ReadAll(block.Body)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode OpenPGP armor: %s", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,173 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Test vectors are made by GnuPG with faked system time: all keys are created on 2020-01-01 and signatures on
+// 2020-02-01 unless stated otherwise.
+//   - alice: Ed25519 primary key with an Ed25519 signing subkey (that makes her signatures)
+//   - bob: RSA 2048 primary key, bob-sha1.sig is made with SHA-1
+//   - carol: revoked Ed25519 key
+//   - dave: Ed25519 key that expired on 2020-02-02, dave-after.sig is made on 2020-06-01 (dave-extended.asc is the
+//     same key with its expiration extended to 2040-01-01 on 2020-03-01)
+//   - erin: Ed25519 primary key with a revoked signing subkey (that made erin.sig)
+const (
+	pgpTestAliceSubkey = "2DDF47EFD720A9F1FD48C9ACCC6B417D2D8AFC04"
+	pgpTestBob         = "20F53ECEC119D911426DB1F0116D5907D2081FC9"
+	pgpTestDave        = "2FD03DD9E3F17D23B0E7C0540AE9DB418E0C0861"
+
+	pgpTestTagSignature    = 2
+	pgpTestTagPublicSubkey = 14
+)
+
+func readPgpTestData(t *testing.T, name string) []byte {
+	raw, err := ioutil.ReadFile(filepath.Join("testdata", "openpgp", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestPgpKeyRingVerifyDetached(t *testing.T) {
+	message := readPgpTestData(t, "message.txt")
+	tampered := append([]byte{}, message...)
+	tampered[0] ^= 1
+
+	for _, tc := range []struct {
+		name      string
+		keys      []string
+		data      []byte
+		signature string
+		signer    string
+		err       string
+	}{
+		{"valid ed25519 subkey", []string{"alice.asc"}, message, "alice.sig", pgpTestAliceSubkey, ""},
+		{"valid text signature", []string{"alice.asc"}, message, "alice-text.asc", pgpTestAliceSubkey, ""},
+		{"valid rsa", []string{"bob.asc"}, message, "bob.sig", pgpTestBob, ""},
+		{"valid with several keys", []string{"alice.asc", "bob.asc"}, message, "bob.sig", pgpTestBob, ""},
+		{"tampered", []string{"alice.asc"}, tampered, "alice.sig", "", "does not match the data"},
+		{"tampered rsa", []string{"bob.asc"}, tampered, "bob.sig", "", "does not match the data"},
+		{"wrong key", []string{"alice.asc"}, message, "bob.sig", "", "untrusted key (116D5907D2081FC9)"},
+		{"sha1", []string{"bob.asc"}, message, "bob-sha1.sig", "", "SHA-1 are not accepted"},
+		{"signed before expiration", []string{"dave.asc"}, message, "dave-before.sig", pgpTestDave, ""},
+		{"signed after expiration", []string{"dave.asc"}, message, "dave-after.sig", "", "after the key"},
+		{"revoked subkey", []string{"erin.asc"}, message, "erin.sig", "", "untrusted key (6AB7E0554A986943: "},
+		{"not a signature", []string{"alice.asc"}, message, "message.txt", "", "no OpenPGP signature found"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			keyRing := &PgpKeyRing{}
+			for _, key := range tc.keys {
+				if err := keyRing.Add(readPgpTestData(t, key)); err != nil {
+					t.Fatalf("cannot add '%s': %s", key, err)
+				}
+			}
+			signer, err := keyRing.VerifyDetached(tc.data, readPgpTestData(t, tc.signature))
+			if tc.err == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				if signer != tc.signer {
+					t.Fatalf("expected signer %s, got %s", tc.signer, signer)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Fatalf("expected error containing '%s', got %v", tc.err, err)
+			}
+		})
+	}
+}
+
+// An expired key must be told apart from an invalid signature so that the caller may look for a refreshed key
+func TestPgpKeyRingExpired(t *testing.T) {
+	keyRing := &PgpKeyRing{}
+	if err := keyRing.Add(readPgpTestData(t, "dave.asc")); err != nil {
+		t.Fatal(err)
+	}
+	_, err := keyRing.VerifyDetached(readPgpTestData(t, "message.txt"), readPgpTestData(t, "dave-after.sig"))
+	if !errors.Is(err, ErrPgpKeyExpired) {
+		t.Fatalf("expected %v, got %v", ErrPgpKeyExpired, err)
+	}
+}
+
+func TestPgpKeyRingAddRevoked(t *testing.T) {
+	err := (&PgpKeyRing{}).Add(readPgpTestData(t, "carol.asc"))
+	if err == nil || !strings.Contains(err.Error(), "is revoked") {
+		t.Fatalf("expected the revoked key to be rejected, got %v", err)
+	}
+}
+
+func TestPgpKeyRingAddPinned(t *testing.T) {
+	var keys []byte
+	for _, name := range []string{"alice.asc", "bob.asc"} {
+		raw, err := pgpDearmor(readPgpTestData(t, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, raw...)
+	}
+	message := readPgpTestData(t, "message.txt")
+
+	keyRing := &PgpKeyRing{}
+	if err := keyRing.AddPinned(keys, strings.ToLower(pgpTestBob)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keyRing.VerifyDetached(message, readPgpTestData(t, "bob.sig")); err != nil {
+		t.Fatalf("pinned key should verify: %s", err)
+	}
+	if _, err := keyRing.VerifyDetached(message, readPgpTestData(t, "alice.sig")); err == nil {
+		t.Fatal("keys other than the pinned one should not be trusted")
+	}
+
+	err := (&PgpKeyRing{}).AddPinned(keys, pgpTestDave)
+	if err == nil || !strings.Contains(err.Error(), "no supported OpenPGP public key with fingerprint") {
+		t.Fatalf("expected missing fingerprint to be reported, got %v", err)
+	}
+}
+
+// Subkeys must be bound to their primary keys - otherwise anybody could attach their subkey to a trusted key
+func TestPgpKeyRingUnboundSubkey(t *testing.T) {
+	raw, err := pgpDearmor(readPgpTestData(t, "alice.asc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stripped bytes.Buffer
+	afterSubkey := false
+	packets := packet.NewOpaqueReader(bytes.NewReader(raw))
+	for {
+		p, err := packets.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch {
+		case p.Tag == pgpTestTagPublicSubkey:
+			afterSubkey = true
+		case p.Tag == pgpTestTagSignature && afterSubkey:
+			continue // drop the binding signature
+		}
+		if err := p.Serialize(&stripped); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	keyRing := &PgpKeyRing{}
+	if err := keyRing.Add(stripped.Bytes()); err != nil {
+		return // rejecting the key altogether is fine as well
+	}
+	message := readPgpTestData(t, "message.txt")
+	_, err = keyRing.VerifyDetached(message, readPgpTestData(t, "alice.sig"))
+	if err == nil || !strings.Contains(err.Error(), "untrusted key") {
+		t.Fatalf("expected the unbound subkey to be untrusted, got %v", err)
+	}
+}
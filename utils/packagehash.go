@@ -0,0 +1,98 @@
+package utils
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"github.com/mholt/archiver"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	PackageHashPrefixH1 = "h1:"
+	PackageHashPrefixZH = "zh:"
+)
+
+// PackageHashH1 computes Terraform's "h1:" hash of an unpacked provider package - it's the same as "Hash1" from
+// golang.org/x/mod/sumdb/dirhash. Keys of the map are file names within the package and values are paths on disk.
+func PackageHashH1(files map[string]string) (string, error) {
+	fileHashes := make(map[string][]byte)
+	for name, path := range files {
+		fileHash, err := hashFile(path)
+		if err != nil {
+			return "", err
+		}
+		fileHashes[name] = fileHash
+	}
+	return packageHashH1(fileHashes)
+}
+
+// PackageHashH1Archive computes Terraform's "h1:" hash of a provider package distributed as an archive without
+// unpacking it to disk - file names are taken from the archive as is, the same way Terraform does it for zip archives
+func PackageHashH1Archive(path string) (string, error) {
+	fileHashes := make(map[string][]byte)
+	err := archiver.Walk(path, func(f archiver.File) error {
+		if f.IsDir() {
+			return nil
+		}
+		name := f.Name()
+		switch header := f.Header.(type) {
+		case zip.FileHeader:
+			name = header.Name
+		case *tar.Header:
+			name = header.Name
+		}
+		if _, ok := fileHashes[name]; ok {
+			return fmt.Errorf("archive has several files named '%s'", name)
+		}
+
+		sink := sha256.New()
+		_, err := io.Copy(sink, f)
+		if err != nil {
+			return err
+		}
+		fileHashes[name] = sink.Sum(nil)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return packageHashH1(fileHashes)
+}
+
+func packageHashH1(fileHashes map[string][]byte) (string, error) {
+	var names []string
+	for name := range fileHashes {
+		if strings.Contains(name, "\n") {
+			return "", fmt.Errorf("file names with newlines are not supported: '%s'", name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	summary := sha256.New()
+	for _, name := range names {
+		_, _ = fmt.Fprintf(summary, "%x  %s\n", fileHashes[name], name)
+	}
+	return PackageHashPrefixH1 + base64.StdEncoding.EncodeToString(summary.Sum(nil)), nil
+}
+
+func hashFile(path string) ([]byte, error) {
+	source, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = source.Close() }()
+
+	sink := sha256.New()
+	_, err = io.Copy(sink, source)
+	if err != nil {
+		return nil, err
+	}
+	return sink.Sum(nil), nil
+}
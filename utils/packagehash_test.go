@@ -0,0 +1,91 @@
+package utils
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// dcrec-v1.0.0.zip is the Go module zip of github.com/decred/dcrd/dcrec@v1.0.0 - Terraform's "h1:" is the same as Go's
+// module hash so the expected value is the one recorded in go.sum files for it
+const packageHashTestH1 = "h1:W+z6Es+Rai3MXYVoPAxYr5U1DGis0Co33scJ6uH2J6o="
+
+func TestPackageHashH1(t *testing.T) {
+	archive := filepath.Join("testdata", "packagehash", "dcrec-v1.0.0.zip")
+	reader, err := zip.OpenReader(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = reader.Close() }()
+
+	dir := t.TempDir()
+	files := make(map[string]string)
+	tarGzPath := filepath.Join(dir, "package.tar.gz")
+	tarGz, err := os.Create(tarGzPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gzipWriter := gzip.NewWriter(tarGz)
+	tarWriter := tar.NewWriter(gzipWriter)
+	for idx, f := range reader.File {
+		source, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := ioutil.ReadAll(source)
+		_ = source.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		path := filepath.Join(dir, string(rune('a'+idx)))
+		if err := ioutil.WriteFile(path, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name] = path
+
+		header := &tar.Header{Name: f.Name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}
+		if err := tarWriter.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tarWriter.Write(content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, closer := range []interface{ Close() error }{tarWriter, gzipWriter, tarGz} {
+		if err := closer.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tc := range []struct {
+		name string
+		hash func() (string, error)
+	}{
+		{"zip", func() (string, error) { return PackageHashH1Archive(archive) }},
+		{"tar.gz", func() (string, error) { return PackageHashH1Archive(tarGzPath) }},
+		{"unpacked", func() (string, error) { return PackageHashH1(files) }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h1, err := tc.hash()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if h1 != packageHashTestH1 {
+				t.Fatalf("expected %s, got %s", packageHashTestH1, h1)
+			}
+		})
+	}
+
+	renamed := make(map[string]string)
+	for name, path := range files {
+		renamed[filepath.Base(name)] = path
+	}
+	if h1, err := PackageHashH1(renamed); err != nil || h1 == packageHashTestH1 {
+		t.Fatalf("file names must contribute to the hash, got %s (%v)", h1, err)
+	}
+}
@@ -0,0 +1,179 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	yml "gopkg.in/ashald/yaml.v2"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	partialSuffix     = ".part"
+	partialMetaSuffix = ".part.yaml"
+)
+
+// errPartialDiscarded means that a partial download cannot be resumed and was discarded so it's retried from scratch
+var errPartialDiscarded = errors.New("partial download discarded")
+
+// partialDownload is stored next to a partial download and records what is needed to resume it
+type partialDownload struct {
+	Url          string `yaml:"url"`
+	ETag         string `yaml:"etag,omitempty"`
+	LastModified string `yaml:"last-modified,omitempty"`
+}
+
+// validator is what the server is asked to compare against so that it sends the rest of the same content only
+func (p partialDownload) validator() string {
+	if p.ETag != "" {
+		return p.ETag
+	}
+	return p.LastModified
+}
+
+// httpDownloadResumable fetches the URL into a .part file in the given dir resuming it (both on retries and across runs)
+// if it's already there and returns the complete file renamed to a unique temp name in the same dir. Progress (if any)
+// is reported after every write.
+func httpDownloadResumable(url, dir string, progress func(part *os.File, size int64)) (*os.File, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	partPath := filepath.Join(dir, HashString(url)+partialSuffix)
+	metaPath := filepath.Join(dir, HashString(url)+partialMetaSuffix)
+
+	// other processes sharing the cache dir may be downloading the same URL - let's wait for them to finish
+	lock, err := LockCacheEntry(partPath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = lock.Unlock() }()
+
+	err = httpRetry(url, func() error {
+		return httpFetchPartial(url, partPath, metaPath, progress)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	complete, err := ioutil.TempFile(dir, fmt.Sprintf("para.raw.*.%s", filepath.Base(url)))
+	if err != nil {
+		return nil, err
+	}
+	_ = complete.Close()
+	err = os.Rename(partPath, complete.Name())
+	if err != nil {
+		_ = os.Remove(complete.Name())
+		return nil, err
+	}
+	_ = os.Remove(metaPath)
+	return os.Open(complete.Name())
+}
+
+func httpFetchPartial(url, partPath, metaPath string, progress func(part *os.File, size int64)) error {
+	var offset int64
+	meta := readPartialMeta(metaPath)
+	if info, err := os.Stat(partPath); err == nil && meta.Url == url && meta.validator() != "" {
+		offset = info.Size()
+	}
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", meta.validator()) // the whole content is sent instead if it changed
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	flags := os.O_WRONLY | os.O_CREATE
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+		if !strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", offset)) {
+			discardPartial(partPath, metaPath)
+			return fmt.Errorf(
+				"unexpected range '%s' while resuming '%s': %w",
+				resp.Header.Get("Content-Range"), UrlRedact(url), errPartialDiscarded,
+			)
+		}
+		flags |= os.O_APPEND
+	case resp.StatusCode == http.StatusOK:
+		// either a new download or the server doesn't support ranges or the content changed - start from scratch
+		flags |= os.O_TRUNC
+		offset = 0
+		meta = partialDownload{Url: url, LastModified: resp.Header.Get("Last-Modified")}
+		if etag := resp.Header.Get("ETag"); !strings.HasPrefix(etag, "W/") {
+			meta.ETag = etag // weak validators cannot be used for ranges
+		}
+		err = writePartialMeta(metaPath, meta)
+		if err != nil {
+			return err
+		}
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		discardPartial(partPath, metaPath)
+		return fmt.Errorf("cannot resume '%s': %s: %w", UrlRedact(url), http.StatusText(resp.StatusCode), errPartialDiscarded)
+	default:
+		return newHttpStatusError(url, resp)
+	}
+
+	part, err := os.OpenFile(partPath, flags, 0644)
+	if err != nil {
+		return err
+	}
+	var writer io.Writer = part
+	if progress != nil {
+		progress(part, offset)
+		writer = &progressWriter{file: part, size: offset, progress: progress}
+	}
+	_, err = io.Copy(writer, resp.Body)
+	errClose := part.Close()
+	if err != nil {
+		return err
+	}
+	return errClose
+}
+
+// progressWriter reports how much has been written to the file so far after every write
+type progressWriter struct {
+	file     *os.File
+	size     int64
+	progress func(file *os.File, size int64)
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	w.progress(w.file, w.size)
+	return n, err
+}
+
+func readPartialMeta(path string) partialDownload {
+	var meta partialDownload
+	raw, err := ioutil.ReadFile(path)
+	if err == nil {
+		_ = yml.UnmarshalStrict(raw, &meta)
+	}
+	return meta
+}
+
+func writePartialMeta(path string, meta partialDownload) error {
+	raw, err := yml.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, raw, 0644)
+}
+
+func discardPartial(partPath, metaPath string) {
+	_ = os.Remove(partPath)
+	_ = os.Remove(metaPath)
+}
@@ -0,0 +1,170 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const partialTestContent = "0123456789abcdefghijklmnopqrstuvwxyz"
+
+// partialTestServer serves the content with the ETag (supporting ranges) and records Range headers of requests
+func partialTestServer(t *testing.T, content, etag string, ranges *[]string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*ranges = append(*ranges, r.Header.Get("Range"))
+		if etag != "" {
+			w.Header().Set("ETag", etag)
+		}
+		http.ServeContent(w, r, "plugin", time.Time{}, strings.NewReader(content))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestHttpFetchPartial(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		etag    string // served
+		part    string // already downloaded
+		meta    string // validator of the partial download
+		ranges  string // requested
+		written string // reported via progress at first
+	}{
+		{"fresh", `"v1"`, "", "", "", "0"},
+		{"resumed", `"v1"`, partialTestContent[:10], `"v1"`, "bytes=10-", "10"},
+		{"changed", `"v2"`, "9876543210", `"v1"`, "bytes=10-", "0"},
+		{"no validator", `"v1"`, partialTestContent[:10], "", "", "0"},
+		{"weak validator", `W/"v1"`, "", "", "", "0"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var ranges []string
+			server := partialTestServer(t, partialTestContent, tc.etag, &ranges)
+			url := server.URL + "/plugin"
+
+			dir := t.TempDir()
+			partPath := filepath.Join(dir, "plugin"+partialSuffix)
+			metaPath := filepath.Join(dir, "plugin"+partialMetaSuffix)
+			if tc.part != "" {
+				if err := ioutil.WriteFile(partPath, []byte(tc.part), 0644); err != nil {
+					t.Fatal(err)
+				}
+				if err := writePartialMeta(metaPath, partialDownload{Url: url, ETag: tc.meta}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var reported []string
+			err := httpFetchPartial(url, partPath, metaPath, func(part *os.File, size int64) {
+				reported = append(reported, fmt.Sprint(size))
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual := strings.Join(ranges, ","); actual != tc.ranges {
+				t.Fatalf("expected range '%s' to be requested, got '%s'", tc.ranges, actual)
+			}
+			if content, _ := ioutil.ReadFile(partPath); string(content) != partialTestContent {
+				t.Fatalf("unexpected content '%s'", content)
+			}
+			if len(reported) == 0 || reported[0] != tc.written || reported[len(reported)-1] != "36" {
+				t.Fatalf("expected progress to go from %s to 36, got %v", tc.written, reported)
+			}
+			expectedETag := tc.etag
+			if strings.HasPrefix(expectedETag, "W/") {
+				expectedETag = "" // weak validators cannot be used for ranges
+			}
+			if meta := readPartialMeta(metaPath); meta.ETag != expectedETag {
+				t.Fatalf("expected ETag '%s' to be recorded, got '%s'", expectedETag, meta.ETag)
+			}
+		})
+	}
+}
+
+func TestHttpFetchPartialDiscarded(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		handler http.HandlerFunc
+	}{
+		{"unexpected range", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-35/%d", len(partialTestContent)))
+			w.WriteHeader(http.StatusPartialContent)
+			_, _ = w.Write([]byte(partialTestContent))
+		}},
+		{"not satisfiable", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(tc.handler)
+			defer server.Close()
+			url := server.URL + "/plugin"
+
+			dir := t.TempDir()
+			partPath := filepath.Join(dir, "plugin"+partialSuffix)
+			metaPath := filepath.Join(dir, "plugin"+partialMetaSuffix)
+			if err := ioutil.WriteFile(partPath, []byte(partialTestContent[:10]), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := writePartialMeta(metaPath, partialDownload{Url: url, ETag: `"v1"`}); err != nil {
+				t.Fatal(err)
+			}
+
+			err := httpFetchPartial(url, partPath, metaPath, nil)
+			if !errors.Is(err, errPartialDiscarded) || !isRetryable(err) {
+				t.Fatalf("expected the partial download to be discarded and retried, got %v", err)
+			}
+			if PathExists(partPath) || PathExists(metaPath) {
+				t.Fatal("partial download must be removed")
+			}
+		})
+	}
+}
+
+// an interrupted download is resumed on retry rather than started over
+func TestHttpDownloadResumable(t *testing.T) {
+	defer func(backoff time.Duration) { httpRetryBackoff = backoff }(httpRetryBackoff)
+	httpRetryBackoff = time.Millisecond
+
+	var ranges []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		w.Header().Set("ETag", `"v1"`)
+		if len(ranges) == 1 {
+			w.Header().Set("Content-Length", fmt.Sprint(len(partialTestContent)))
+			_, _ = w.Write([]byte(partialTestContent[:20]))
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler) // drops the connection
+		}
+		http.ServeContent(w, r, "plugin", time.Time{}, strings.NewReader(partialTestContent))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	file, err := httpDownloadResumable(server.URL+"/plugin", dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = file.Close() }()
+	content, err := ioutil.ReadAll(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != partialTestContent {
+		t.Fatalf("unexpected content '%s'", content)
+	}
+	if actual := strings.Join(ranges, ","); actual != ",bytes=20-" {
+		t.Fatalf("expected the download to be resumed, got ranges '%s'", actual)
+	}
+	for _, suffix := range []string{partialSuffix, partialMetaSuffix} {
+		if leftovers, _ := filepath.Glob(filepath.Join(dir, "*"+suffix)); len(leftovers) > 0 {
+			t.Fatalf("partial download must be cleaned up, got %v", leftovers)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"github.com/mitchellh/go-homedir"
 	"net/url"
 	"path"
 	"strings"
@@ -16,6 +17,11 @@ func UrlIsRemote(url string) bool {
 	return strings.HasPrefix(url, schemaHttp) || strings.HasPrefix(url, schemaHttps)
 }
 
+// UrlToPath returns a local path for a file:// URL or a plain path (with "~" expanded)
+func UrlToPath(url string) (string, error) {
+	return homedir.Expand(strings.TrimPrefix(url, schemaFile))
+}
+
 func UrlJoin(base string, elems ...string) string {
 	u, err := url.Parse(base)
 	if err != nil {
@@ -24,3 +30,23 @@ func UrlJoin(base string, elems ...string) string {
 	u.Path = path.Join(append([]string{u.Path}, elems...)...)
 	return u.String()
 }
+
+// UrlResolve resolves a relative path or URL against the location (a URL or a path) of the document referencing it
+func UrlResolve(base, ref string) string {
+	if UrlIsRemote(ref) || strings.HasPrefix(ref, schemaFile) || strings.HasPrefix(ref, "~") || path.IsAbs(ref) {
+		return ref
+	}
+	if UrlIsRemote(base) {
+		baseUrl, errBase := url.Parse(base)
+		refUrl, errRef := url.Parse(ref)
+		if errBase != nil || errRef != nil {
+			return ref
+		}
+		return baseUrl.ResolveReference(refUrl).String()
+	}
+	basePath, err := UrlToPath(base)
+	if err != nil {
+		return ref
+	}
+	return path.Join(path.Dir(basePath), ref)
+}
@@ -0,0 +1,38 @@
+package utils
+
+import (
+	"github.com/mitchellh/go-homedir"
+	"testing"
+)
+
+func TestUrlResolve(t *testing.T) {
+	home, err := homedir.Dir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		base     string
+		ref      string
+		expected string
+	}{
+		{"https://example.com/para/index.yaml", "extra.yaml", "https://example.com/para/extra.yaml"},
+		{"https://example.com/para/index.yaml", "./extra/more.yaml", "https://example.com/para/extra/more.yaml"},
+		{"https://example.com/para/index.yaml", "../shared.yaml", "https://example.com/shared.yaml"},
+		{"https://example.com/para/index.yaml?ref=main", "extra.yaml", "https://example.com/para/extra.yaml"},
+		{"https://example.com/para/index.yaml", "https://other.com/a.yaml", "https://other.com/a.yaml"},
+		{"https://example.com/para/index.yaml", "file:///etc/para.yaml", "file:///etc/para.yaml"},
+		{"https://example.com/para/index.yaml", "/abs/para.yaml", "/abs/para.yaml"},
+		{"/etc/para/index.yaml", "extra.yaml", "/etc/para/extra.yaml"},
+		{"/etc/para/index.yaml", "../shared.yaml", "/etc/shared.yaml"},
+		{"file:///etc/para/index.yaml", "extra.yaml", "/etc/para/extra.yaml"},
+		{"~/para/index.yaml", "extra.yaml", home + "/para/extra.yaml"},
+		{"/etc/para/index.yaml", "~/extra.yaml", "~/extra.yaml"},
+		{"/etc/para/index.yaml", "http://example.com/a.yaml", "http://example.com/a.yaml"},
+	} {
+		t.Run(tc.base+" + "+tc.ref, func(t *testing.T) {
+			if actual := UrlResolve(tc.base, tc.ref); actual != tc.expected {
+				t.Fatalf("expected '%s', got '%s'", tc.expected, actual)
+			}
+		})
+	}
+}